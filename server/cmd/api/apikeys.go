@@ -0,0 +1,265 @@
+//Filename: cmd/api/apikeys.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// the requests a minute a key gets when none is asked for
+const defaultAPIKeyRateLimit = 60
+
+// apiKeyLimiters rate limits each key to the requests a minute set on it
+type apiKeyLimiters struct {
+	mu       sync.Mutex
+	limiters map[int64]*apiKeyLimiter
+}
+
+type apiKeyLimiter struct {
+	limiter  *rate.Limiter
+	perMin   int
+	lastSeen time.Time
+}
+
+// allow reports whether the key may make another request now
+func (l *apiKeyLimiters) allow(key *data.APIKey) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limiters == nil {
+		l.limiters = make(map[int64]*apiKeyLimiter)
+	}
+
+	//a changed limit starts a new limiter
+	kl, ok := l.limiters[key.ID]
+	if !ok || kl.perMin != key.RateLimit {
+		burst := key.RateLimit / 10
+		if burst < 1 {
+			burst = 1
+		}
+		kl = &apiKeyLimiter{
+			limiter: rate.NewLimiter(rate.Limit(float64(key.RateLimit)/60), burst),
+			perMin:  key.RateLimit,
+		}
+		l.limiters[key.ID] = kl
+	}
+
+	kl.lastSeen = time.Now()
+
+	return kl.limiter.Allow()
+}
+
+// prune forgets keys that have not been used for a while
+func (l *apiKeyLimiters) prune(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for id, kl := range l.limiters {
+		if time.Since(kl.lastSeen) > idle {
+			delete(l.limiters, id)
+		}
+	}
+}
+
+// runAPIKeyFlusher saves the last used times of keys every interval and once more
+// when ctx is cancelled, and drops the limiters of idle keys
+func (app *application) runAPIKeyFlusher(ctx context.Context) {
+
+	ticker := time.NewTicker(sessionFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			app.flushAPIKeys()
+			return
+		case <-ticker.C:
+			app.flushAPIKeys()
+			app.apiKeyLimiters.prune(10 * time.Minute)
+		}
+	}
+}
+
+func (app *application) flushAPIKeys() {
+
+	err := app.models.APIKeys.TouchLastUsed(app.apiKeysUsed.drain())
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// authenticateAPIKey signs the request in as the user who made the key, the key's
+// scopes limit what it can do
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+
+	key, err := app.models.APIKeys.GetForPlaintext(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if key.Expired(time.Now()) {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	if !key.AllowsIP(clientIP(r)) {
+		app.notPerrmittedResponse(w, r)
+		return
+	}
+
+	if !app.apiKeyLimiters.allow(key) {
+		app.rateLimitExceedeResponse(w, r)
+		return
+	}
+
+	app.apiKeysUsed.touch(key.Hash)
+
+	r = app.contextSetUser(r, &data.User{ID: key.UserID, Activated: key.UserActivated})
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
+// create an API key, the key itself is only shown in this response
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name           string   `json:"name"`
+		Scopes         []string `json:"scopes"`
+		AllowedIPs     []string `json:"allowed_ips"`
+		RateLimit      *int     `json:"rate_limit"`
+		ExpiresAt      string   `json:"expires_at"`
+		OrganizationID *int64   `json:"organization_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	key := &data.APIKey{
+		Name:           input.Name,
+		UserID:         user.ID,
+		OrganizationID: input.OrganizationID,
+		Scopes:         input.Scopes,
+		AllowedIPs:     input.AllowedIPs,
+		RateLimit:      defaultAPIKeyRateLimit,
+	}
+
+	if key.AllowedIPs == nil {
+		key.AllowedIPs = []string{}
+	}
+
+	if input.RateLimit != nil {
+		key.RateLimit = *input.RateLimit
+	}
+
+	v := validator.New()
+
+	if input.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, input.ExpiresAt)
+		if err != nil {
+			v.AddError("expires_at", "must be a date and time like 2026-01-02T15:04:05Z")
+		} else {
+			key.ExpiresAt = &expiresAt
+		}
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateAPIKey(v, key, permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//only the agency's brokers and admins may make keys for it
+	if key.OrganizationID != nil {
+		member, err := app.models.Organizations.GetMember(*key.OrganizationID, user.ID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if member == nil || !member.CanManage() {
+			app.notPerrmittedResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/api-keys/%d", key.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the user's keys and the keys of agencies they manage
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+
+	keys, err := app.models.APIKeys.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revoke a key
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "api key revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
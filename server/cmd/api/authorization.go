@@ -0,0 +1,71 @@
+//Filename: cmd/api/authorization.go
+
+package main
+
+import (
+	"net/http"
+
+	"realestatebelize.imerlopez.net/internal/data"
+)
+
+// isAdmin checks if the signed in user has the admin role
+func (app *application) isAdmin(r *http.Request) (bool, error) {
+
+	//an API key only acts as an admin when it was given the admin's permissions:write scope
+	if key := app.contextGetAPIKey(r); key != nil && !data.Permissions(key.Scopes).Includes(permissionsWrite) {
+		return false, nil
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, role := range roles {
+		if role == data.RoleAdmin {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// canEditListing checks if the signed in user is the owning agent of the listing,
+// a broker over it or an admin
+func (app *application) canEditListing(r *http.Request, listingID int64) (bool, error) {
+
+	ok, err := app.models.UserListings.CanManage(app.contextGetUser(r).ID, listingID)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	return app.isAdmin(r)
+}
+
+// allowListingEdit writes the not permitted response and returns false unless the
+// signed in user can edit the listing
+func (app *application) allowListingEdit(w http.ResponseWriter, r *http.Request, listingID int64) bool {
+
+	allowed, err := app.canEditListing(r, listingID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !allowed {
+		app.notPerrmittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// canEditUser checks if the signed in user is the user being edited or an admin
+func (app *application) canEditUser(r *http.Request, userID int64) (bool, error) {
+
+	if app.contextGetUser(r).ID == userID {
+		return true, nil
+	}
+
+	return app.isAdmin(r)
+}
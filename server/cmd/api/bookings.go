@@ -0,0 +1,181 @@
+//Filename: cmd/api/bookings.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// request a stay at a vacation rental, the nights are held until the booking is
+// declined or cancelled
+func (app *application) createBookingHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		CheckIn  string `json:"check_in"`
+		CheckOut string `json:"check_out"`
+		Guests   int32  `json:"guests"`
+		Message  string `json:"message"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	booking := &data.Booking{
+		ListingID: id,
+		GuestID:   app.contextGetUser(r).ID,
+		CheckIn:   parseDateInput(v, "check_in", input.CheckIn),
+		CheckOut:  parseDateInput(v, "check_out", input.CheckOut),
+		Guests:    input.Guests,
+		Message:   input.Message,
+	}
+
+	if data.ValidateBooking(v, booking); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Bookings.Insert(booking)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrNotVacationRental):
+			app.notVacationRentalResponse(w, r)
+		case errors.Is(err, data.ErrDatesUnavailable):
+			app.datesUnavailableResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/bookings/%d", booking.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"booking": booking}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readBooking fetches the booking of the request, only the guest and the users who
+// can edit the listing may see it
+func (app *application) readBooking(w http.ResponseWriter, r *http.Request) (*data.Booking, bool, bool) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false, false
+	}
+
+	booking, err := app.models.Bookings.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false, false
+	}
+
+	user := app.contextGetUser(r)
+
+	isOwner, err := app.canEditListing(r, booking.ListingID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false, false
+	}
+
+	if booking.GuestID != user.ID && !isOwner {
+		app.notFoundResponse(w, r)
+		return nil, false, false
+	}
+
+	return booking, isOwner, true
+}
+
+// show a booking
+func (app *application) showBookingHandler(w http.ResponseWriter, r *http.Request) {
+
+	booking, _, ok := app.readBooking(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"booking": booking}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirm, decline or cancel a booking. Only users who can edit the listing may confirm
+// or decline, the guest may cancel their own booking
+func (app *application) updateBookingHandler(w http.ResponseWriter, r *http.Request) {
+
+	booking, isOwner, ok := app.readBooking(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Status != data.BookingCancelled && !isOwner {
+		app.notPerrmittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(validator.In(input.Status, data.BookingConfirmed, data.BookingDeclined, data.BookingCancelled), "status", "must be confirmed, declined or cancelled")
+	if v.Valid() {
+		v.Check(booking.CanMoveTo(input.Status), "status", fmt.Sprintf("a %s booking cannot be %s", booking.Status, input.Status))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	booking.Status = input.Status
+
+	err = app.models.Bookings.UpdateStatus(booking)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"booking": booking}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,482 @@
+//Filename: cmd/api/calendar.go
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/ical"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+const (
+	// the largest .ics file that will be imported
+	maxCalendarBytes = 1_048_576
+	// the most redirects followed when fetching a feed
+	maxCalendarRedirects = 3
+)
+
+// readVacationRental fetches the listing of the request and writes the error
+// response when it is missing or not let by the night
+func (app *application) readVacationRental(w http.ResponseWriter, r *http.Request) (*data.CalendarListing, bool) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	listing, err := app.models.Calendar.GetListing(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !listing.IsVacationRental() {
+		app.notVacationRentalResponse(w, r)
+		return nil, false
+	}
+
+	return listing, true
+}
+
+// parseDateInput reads a YYYY-MM-DD value from a json body, an empty value is the zero time
+func parseDateInput(v *validator.Validator, key, value string) time.Time {
+
+	if value == "" {
+		return time.Time{}
+	}
+
+	date, err := time.Parse(dateLayout, value)
+	if err != nil {
+		v.AddError(key, "must be a date in the format YYYY-MM-DD")
+		return time.Time{}
+	}
+
+	return date
+}
+
+// show which nights of a vacation rental are free and what they cost
+func (app *application) showAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+
+	listing, ok := app.readVacationRental(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	//the next 30 nights unless told otherwise
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := app.readDate(qs, "from", today, v)
+	to := app.readDate(qs, "to", from.AddDate(0, 0, 30), v)
+
+	if data.ValidateDateRange(v, "from", "to", from, to); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	days, err := app.models.Calendar.GetCalendar(listing, from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"listing_id": listing.ID, "availability": days}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// set the nightly rate of a range of nights
+func (app *application) createNightlyRateHandler(w http.ResponseWriter, r *http.Request) {
+
+	listing, ok := app.readVacationRental(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.allowListingEdit(w, r, listing.ID) {
+		return
+	}
+
+	var input struct {
+		StartDate   string  `json:"start_date"`
+		EndDate     string  `json:"end_date"`
+		NightlyRate float64 `json:"nightly_rate"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	rate := &data.NightlyRate{
+		ListingID:   listing.ID,
+		StartDate:   parseDateInput(v, "start_date", input.StartDate),
+		EndDate:     parseDateInput(v, "end_date", input.EndDate),
+		NightlyRate: input.NightlyRate,
+	}
+
+	if data.ValidateNightlyRate(v, rate); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Calendar.InsertRate(rate)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"nightly_rate": rate}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// close a range of nights to bookings
+func (app *application) createBlockedDatesHandler(w http.ResponseWriter, r *http.Request) {
+
+	listing, ok := app.readVacationRental(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.allowListingEdit(w, r, listing.ID) {
+		return
+	}
+
+	var input struct {
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+		Reason    string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	block := &data.BlockedDates{
+		ListingID: listing.ID,
+		StartDate: parseDateInput(v, "start_date", input.StartDate),
+		EndDate:   parseDateInput(v, "end_date", input.EndDate),
+		Reason:    input.Reason,
+		Source:    data.BlockSourceManual,
+	}
+
+	if data.ValidateBlockedDates(v, block); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Calendar.InsertBlock(block)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDatesUnavailable):
+			app.datesUnavailableResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"blocked_dates": block}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reopen the nights of a block
+func (app *application) deleteBlockedDatesHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	listingID, err := app.models.Calendar.GetBlockListing(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.allowListingEdit(w, r, listingID) {
+		return
+	}
+
+	err = app.models.Calendar.DeleteBlock(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "blocked dates successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// export the bookings and blocks of a listing so other calendars can subscribe to it,
+// imported blocks are left out so two calendars syncing with each other do not echo
+func (app *application) exportCalendarHandler(w http.ResponseWriter, r *http.Request) {
+
+	listing, ok := app.readVacationRental(w, r)
+	if !ok {
+		return
+	}
+
+	bookings, err := app.models.Bookings.GetUpcoming(listing.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	blocks, err := app.models.Calendar.GetManualBlocks(listing.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	err = ical.Write(&buf, listing.PropertyTitle, calendarEvents(r.Host, bookings, blocks))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="listing-%d.ics"`, listing.ID))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// calendarEvents turns the bookings and blocks of a listing into the events of its feed,
+// guest details are not shared, only that the nights are taken
+func calendarEvents(host string, bookings []*data.Booking, blocks []*data.BlockedDates) []ical.Event {
+
+	events := []ical.Event{}
+
+	for _, booking := range bookings {
+		events = append(events, ical.Event{
+			UID:     fmt.Sprintf("booking-%d@%s", booking.ID, host),
+			Summary: "Booked",
+			Start:   booking.CheckIn,
+			End:     booking.CheckOut,
+		})
+	}
+
+	for _, block := range blocks {
+		events = append(events, ical.Event{
+			UID:     fmt.Sprintf("block-%d@%s", block.ID, host),
+			Summary: "Not available",
+			Start:   block.StartDate,
+			End:     block.EndDate,
+		})
+	}
+
+	return events
+}
+
+// importedBlocks turns the events of another calendar into blocked dates, events that
+// ended before today are of no use to the calendar
+func importedBlocks(events []ical.Event, today time.Time) []*data.BlockedDates {
+
+	blocks := []*data.BlockedDates{}
+
+	for _, event := range events {
+		if !event.End.After(today) {
+			continue
+		}
+
+		blocks = append(blocks, &data.BlockedDates{
+			StartDate: event.Start,
+			EndDate:   event.End,
+			Reason:    event.Summary,
+			UID:       event.UID,
+		})
+	}
+
+	return blocks
+}
+
+// import the events of another calendar as blocked dates, the .ics file is either the
+// request body (Content-Type: text/calendar) or fetched from {"url": "https://..."}.
+// Importing the same url again replaces the blocks of the last import
+func (app *application) importCalendarHandler(w http.ResponseWriter, r *http.Request) {
+
+	listing, ok := app.readVacationRental(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.allowListingEdit(w, r, listing.ID) {
+		return
+	}
+
+	var body io.Reader
+	source := "upload"
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType == "text/calendar" {
+		body = http.MaxBytesReader(w, r.Body, maxCalendarBytes)
+	} else {
+		var input struct {
+			URL string `json:"url"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		v := validator.New()
+
+		u, err := url.Parse(input.URL)
+		v.Check(input.URL != "", "url", "must be provided")
+		v.Check(err == nil && u.Scheme == "https" && u.Host != "", "url", "must be an https url")
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		content, err := app.fetchCalendar(input.URL)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		body = bytes.NewReader(content)
+		source = input.URL
+	}
+
+	events, err := ical.Parse(body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	blocks := importedBlocks(events, time.Now().UTC().Truncate(24*time.Hour))
+
+	err = app.models.Calendar.ReplaceImportedBlocks(listing.ID, source, blocks)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"source": source, "imported": len(blocks), "blocked_dates": blocks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// fetchCalendar downloads an .ics feed. The url comes from the client so only public
+// addresses are dialed, checked after the name is resolved so a redirect or a dns
+// answer cannot point it at our own network
+func (app *application) fetchCalendar(feedURL string) ([]byte, error) {
+
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: publicAddressOnly,
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			//no proxy, it would be dialed in place of the feed
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxCalendarRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxCalendarRedirects)
+			}
+			if req.URL.Scheme != "https" {
+				return errors.New("redirected to a url that is not https")
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Get(feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch the calendar: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch the calendar: %s", resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxCalendarBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch the calendar: %w", err)
+	}
+
+	if len(content) > maxCalendarBytes {
+		return nil, fmt.Errorf("the calendar must not be larger than %d bytes", maxCalendarBytes)
+	}
+
+	return content, nil
+}
+
+// sharedAddressSpace is the carrier grade nat range, it is not public either
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddressOnly refuses to connect to loopback, private, link-local and other
+// addresses that are not on the public internet
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := addrPort.Addr().Unmap()
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%s is not a public address", ip)
+	}
+
+	return nil
+}
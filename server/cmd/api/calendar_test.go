@@ -0,0 +1,143 @@
+//Filename: cmd/api/calendar_test.go
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/ical"
+)
+
+func mustDate(s string) time.Time {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestImportedBlocksFromFixture(t *testing.T) {
+
+	f, err := os.Open("../../internal/ical/testdata/airbnb.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	events, err := ical.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the first reservation has ended by the 10th so only the second is kept
+	blocks := importedBlocks(events, mustDate("2026-11-10"))
+
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+
+	got := blocks[0]
+	if got.UID != "1418fb94e984-0b52c6e1f7a3d948@airbnb.com" || got.Reason != "Airbnb (Not available)" ||
+		!got.StartDate.Equal(mustDate("2026-11-20")) || !got.EndDate.Equal(mustDate("2026-11-24")) {
+		t.Errorf("unexpected block %+v", got)
+	}
+
+	//a stay checking out today no longer blocks anything
+	if blocks := importedBlocks(events, mustDate("2026-11-24")); len(blocks) != 0 {
+		t.Errorf("got %d blocks after every stay ended, want 0", len(blocks))
+	}
+}
+
+func TestCalendarEventsExport(t *testing.T) {
+
+	bookings := []*data.Booking{
+		{ID: 7, GuestID: 42, CheckIn: mustDate("2026-11-01"), CheckOut: mustDate("2026-11-05"), Message: "arriving late"},
+	}
+	blocks := []*data.BlockedDates{
+		{ID: 3, StartDate: mustDate("2026-11-20"), EndDate: mustDate("2026-11-24"), Reason: "repairs"},
+	}
+
+	var buf bytes.Buffer
+
+	err := ical.Write(&buf, "Villa", calendarEvents("api.example.com", bookings, blocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//guest details stay private
+	if strings.Contains(buf.String(), "arriving late") || strings.Contains(buf.String(), "repairs") {
+		t.Fatalf("the feed leaks booking or block details:\n%s", buf.String())
+	}
+
+	events, err := ical.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ical.Event{
+		{UID: "booking-7@api.example.com", Summary: "Booked", Start: mustDate("2026-11-01"), End: mustDate("2026-11-05")},
+		{UID: "block-3@api.example.com", Summary: "Not available", Start: mustDate("2026-11-20"), End: mustDate("2026-11-24")},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d:\ngot  %+v\nwant %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestPublicAddressOnly(t *testing.T) {
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:443", false},
+		{"[::1]:443", false},
+		{"10.0.0.5:443", false},
+		{"172.16.3.4:443", false},
+		{"192.168.1.1:443", false},
+		{"169.254.169.254:80", false},
+		{"100.64.0.1:443", false},
+		{"0.0.0.0:443", false},
+		{"[fd00::1]:443", false},
+		{"[fe80::1]:443", false},
+		{"[::ffff:127.0.0.1]:443", false},
+		{"224.0.0.1:443", false},
+	}
+
+	for _, tt := range tests {
+		err := publicAddressOnly("tcp", tt.address, nil)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: got %v, want allowed=%v", tt.address, err, tt.allowed)
+		}
+	}
+}
+
+func TestFetchCalendarRefusesLocalServers(t *testing.T) {
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the calendar was fetched from a loopback address")
+	}))
+	defer srv.Close()
+
+	app := &application{}
+
+	_, err := app.fetchCalendar(srv.URL)
+	if err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Fatalf("got %v, want the address to be refused", err)
+	}
+}
@@ -0,0 +1,142 @@
+//Filename: cmd/api/closingcosts.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// estimate the transfer tax and fees a buyer pays on a listing
+func (app *application) showListingClosingCostsHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	buyer := app.readString(r.URL.Query(), "buyer", "resident")
+	v.Check(validator.In(buyer, data.BuyerTypes...), "buyer", "must be resident or foreign")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//fetch the listing for its price
+	listing, err := app.models.Listing.GetPrice(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if data.ValidateSaleListing(v, listing.ListingType); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rule, err := app.models.ClosingCostRules.Get(buyer)
+	if err != nil {
+		//the rules are seeded so a missing one is a server problem
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"listing_id": listing.ID, "closing_costs": rule.Estimate(listing.Price)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// show the closing cost rules in use
+func (app *application) listClosingCostRulesHandler(w http.ResponseWriter, r *http.Request) {
+
+	rules, err := app.models.ClosingCostRules.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"closing_cost_rules": rules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// update the rule for a buyer type without a deploy
+func (app *application) updateClosingCostRuleHandler(w http.ResponseWriter, r *http.Request) {
+
+	rule, err := app.models.ClosingCostRules.Get(app.readStringParam(r, "buyer"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		TransferTaxRate    *float64 `json:"transfer_tax_rate"`
+		ExemptionThreshold *float64 `json:"exemption_threshold"`
+		LegalFeeRate       *float64 `json:"legal_fee_rate"`
+		TitleSearchFee     *float64 `json:"title_search_fee"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.TransferTaxRate != nil {
+		rule.TransferTaxRate = *input.TransferTaxRate
+	}
+
+	if input.ExemptionThreshold != nil {
+		rule.ExemptionThreshold = *input.ExemptionThreshold
+	}
+
+	if input.LegalFeeRate != nil {
+		rule.LegalFeeRate = *input.LegalFeeRate
+	}
+
+	if input.TitleSearchFee != nil {
+		rule.TitleSearchFee = *input.TitleSearchFee
+	}
+
+	v := validator.New()
+
+	if data.ValidateClosingCostRule(v, rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ClosingCostRules.Update(rule)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"closing_cost_rule": rule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
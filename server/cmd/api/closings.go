@@ -0,0 +1,130 @@
+//Filename: cmd/api/closings.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// record the actual sale/lease of a listing
+func (app *application) createClosingHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//only the owning agent, their broker or an admin may close the listing
+	if !app.allowListingEdit(w, r, id) {
+		return
+	}
+
+	//our target decode distination
+	var input struct {
+		SalePrice       float64 `json:"sale_price"`
+		CloseDate       string  `json:"close_date"`
+		BuyerAgentID    *int64  `json:"buyer_agent_id"`
+		LeaseTermMonths *int32  `json:"lease_term_months"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//Initialize a new Validator instance
+	v := validator.New()
+
+	//close date is sent as YYYY-MM-DD
+	closeDate, err := time.Parse(dateLayout, input.CloseDate)
+	if err != nil {
+		v.AddError("close_date", "must be a date in the format YYYY-MM-DD")
+	}
+
+	closing := &data.Closing{
+		ListingID:       id,
+		SalePrice:       input.SalePrice,
+		CloseDate:       closeDate,
+		BuyerAgentID:    input.BuyerAgentID,
+		LeaseTermMonths: input.LeaseTermMonths,
+	}
+
+	if data.ValidateClosing(v, closing); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//make sure the listing exists before recording the closing, listings without
+	//images or an agent can be closed too
+	listing, err := app.models.Listing.GetPrice(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//rentals are closed as leases and only they count as leased in the reports
+	if data.ValidateClosingType(v, closing, listing.ListingType); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Closings.Insert(closing)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateClosing):
+			v.AddError("listing_id", "a closing has already been recorded for this listing")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/listings/%d/closing", closing.ListingID))
+
+	//write json response with 201
+	err = app.writeJSON(w, http.StatusCreated, envelope{"closing": closing}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// show the closing record of a listing
+func (app *application) showClosingHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	closing, err := app.models.Closings.GetForListing(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"closing": closing}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/signedtoken"
 )
 
 // Define a custom contextKey type
@@ -15,6 +16,15 @@ type contextKey string
 // make user a key
 const userContextKey = contextKey("user")
 
+// the hash of the authentication token of the request
+const tokenContextKey = contextKey("token")
+
+// the claims of a signed authentication token
+const claimsContextKey = contextKey("claims")
+
+// the API key a request was made with
+const apiKeyContextKey = contextKey("apiKey")
+
 // method to add user to the context
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 
@@ -33,3 +43,45 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// add the hash of the request's authentication token to the context
+func (app *application) contextSetToken(r *http.Request, hash []byte) *http.Request {
+
+	ctx := context.WithValue(r.Context(), tokenContextKey, hash)
+	return r.WithContext(ctx)
+}
+
+// retrieve the token hash, anonymous requests have none
+func (app *application) contextGetToken(r *http.Request) []byte {
+
+	hash, _ := r.Context().Value(tokenContextKey).([]byte)
+	return hash
+}
+
+// add the claims of a signed token to the context
+func (app *application) contextSetClaims(r *http.Request, claims *signedtoken.Claims) *http.Request {
+
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// retrieve the claims, requests without a signed token have none
+func (app *application) contextGetClaims(r *http.Request) *signedtoken.Claims {
+
+	claims, _ := r.Context().Value(claimsContextKey).(*signedtoken.Claims)
+	return claims
+}
+
+// add the API key of the request to the context
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// retrieve the API key, requests signed in with a token have none
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
@@ -4,7 +4,12 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -88,6 +93,50 @@ func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *htt
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// Invalid, expired or reused refresh token
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// Too many failed logins, the client is told when to try again
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, throttle *data.LoginThrottle) {
+	seconds := int(math.Ceil(throttle.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := fmt.Sprintf("too many failed login attempts, try again in %d seconds", seconds)
+	if throttle.Locked {
+		message = "this account is temporarily locked after too many failed login attempts, check your email to unlock it"
+	}
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// Unknown or expired API key
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired api key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// A personal data export was asked for too recently
+func (app *application) dataExportThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "a data export was requested recently, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// Sign in through an identity provider did not go through
+func (app *application) oidcSignInFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "sign in with the identity provider failed or expired, please start again"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// The outside identity is not linked to an account and may not make one
+func (app *application) oidcNoAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "no account is linked to this identity"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // Invalid Token
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
@@ -108,8 +157,38 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// The account must turn on two-factor authentication first
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must enable two-factor authentication to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// Two-factor authentication was turned on already
+func (app *application) mfaEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 // Users does not have the required permission(read/write)
 func (app *application) notPerrmittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account does not have the necessary permission to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// Not enough sold listings to value a property
+func (app *application) noComparablesResponse(w http.ResponseWriter, r *http.Request) {
+	message := "there are not enough comparable sales to estimate a value for this property"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+// Calendar and bookings are only kept for listings let by the night
+func (app *application) notVacationRentalResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this listing is not a nightly rental"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+// Some of the nights asked for are booked or blocked
+func (app *application) datesUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "some of the requested nights are already booked or blocked"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
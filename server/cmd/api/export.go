@@ -0,0 +1,161 @@
+//Filename: cmd/api/export.go
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/validator"
+	"realestatebelize.imerlopez.net/internal/xlsx"
+)
+
+// the response formats a client can ask for
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+)
+
+// tableWriter writes the rows of a report or listing export
+type tableWriter interface {
+	WriteRow(values ...interface{}) error
+	Close() error
+}
+
+// readFormat picks the response format from the format query parameter,
+// falling back to the Accept header and then to json
+func (app *application) readFormat(r *http.Request, v *validator.Validator) string {
+
+	format := app.readString(r.URL.Query(), "format", "")
+
+	if format != "" {
+		v.Check(validator.In(format, formatJSON, formatCSV, formatXLSX), "format", "must be one of json, csv or xlsx")
+		return format
+	}
+
+	accept := r.Header.Get("Accept")
+
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		return formatCSV
+	case strings.Contains(accept, xlsx.ContentType):
+		return formatXLSX
+	}
+
+	return formatJSON
+}
+
+// newTableWriter sets the download headers for the format and writes the header row
+func (app *application) newTableWriter(w http.ResponseWriter, format, name string, header []string) (tableWriter, error) {
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format(dateLayout), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var tw tableWriter
+
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		tw = &csvWriter{w: csv.NewWriter(w), flusher: flusherFor(w)}
+	case formatXLSX:
+		w.Header().Set("Content-Type", xlsx.ContentType)
+		xw, err := xlsx.NewWriter(w, name)
+		if err != nil {
+			return nil, err
+		}
+		tw = xw
+	default:
+		panic("unsupported table format: " + format)
+	}
+
+	values := make([]interface{}, len(header))
+	for i := range header {
+		values[i] = header[i]
+	}
+
+	return tw, tw.WriteRow(values...)
+}
+
+// closeTableWriter finishes the download, the headers have already gone out
+// by now so a failure can only be logged
+func (app *application) closeTableWriter(r *http.Request, tw tableWriter, err error) {
+	if err == nil {
+		err = tw.Close()
+	}
+
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// csvWriter streams rows as csv, flushing to the client every few rows
+type csvWriter struct {
+	w       *csv.Writer
+	flusher http.Flusher
+	rows    int
+}
+
+func (c *csvWriter) WriteRow(values ...interface{}) error {
+
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = formatCell(value)
+	}
+
+	err := c.w.Write(record)
+	if err != nil {
+		return err
+	}
+
+	c.rows++
+	if c.rows%100 == 0 {
+		c.w.Flush()
+		if c.flusher != nil {
+			c.flusher.Flush()
+		}
+	}
+
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// formatCell turns a value into its csv text
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return escapeFormula(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// escapeFormula stops spreadsheets from running text that starts like a formula,
+// the cell is shown with a leading ' instead
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func flusherFor(w http.ResponseWriter) http.Flusher {
+	flusher, _ := w.(http.Flusher)
+	return flusher
+}
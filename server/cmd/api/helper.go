@@ -12,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"realestatebelize.imerlopez.net/internal/validator"
@@ -21,13 +22,21 @@ import (
 
 type envelope map[string]interface{}
 
+// dates sent by the client are in the YYYY-MM-DD format
+const dateLayout = "2006-01-02"
+
 func (app *application) readIdParam(r *http.Request) (int64, error) {
+	return app.readNamedIdParam(r, "id")
+}
+
+// readNamedIdParam reads an id from a route with more than one id in it
+func (app *application) readNamedIdParam(r *http.Request, name string) (int64, error) {
 
 	//ParamsFromContext() function to get the request context as a slice
 	params := httprouter.ParamsFromContext(r.Context())
 
 	//get id from params
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
 
 		return 0, errors.New("invalid id parament")
@@ -37,6 +46,14 @@ func (app *application) readIdParam(r *http.Request) (int64, error) {
 
 }
 
+// readStringParam returns a named string parameter from the route
+func (app *application) readStringParam(r *http.Request, name string) string {
+
+	params := httprouter.ParamsFromContext(r.Context())
+
+	return params.ByName(name)
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 
 	//convert map result into JSON data
@@ -188,6 +205,74 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return intValue
 }
 
+// the readFloat method converts a string value to a float64 value
+// if the value cannot converted to a number then a validation error is added to
+// the validation errors map
+
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+
+	//get the value
+	value := qs.Get(key)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	floatValue, err := strconv.ParseFloat(value, 64)
+
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+
+	return floatValue
+}
+
+// the readDate method converts a YYYY-MM-DD string value to a time.Time value
+// if the value cannot be parsed then a validation error is added to
+// the validation errors map
+
+func (app *application) readDate(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+
+	//get the value
+	value := qs.Get(key)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	dateValue, err := time.Parse(dateLayout, value)
+
+	if err != nil {
+		v.AddError(key, "must be a date in the format YYYY-MM-DD")
+		return defaultValue
+	}
+
+	return dateValue
+}
+
+// the readOptionalBool method returns nil when the key is missing so that
+// callers can tell "not filtered" apart from false
+
+func (app *application) readOptionalBool(qs url.Values, key string, v *validator.Validator) *bool {
+
+	//get the value
+	value := qs.Get(key)
+
+	if value == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(value)
+
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return nil
+	}
+
+	return &b
+}
+
 // background accepts a function as its parameter
 func (app *application) background(fn func()) {
 
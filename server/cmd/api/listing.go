@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"realestatebelize.imerlopez.net/internal/data"
 	"realestatebelize.imerlopez.net/internal/validator"
@@ -18,10 +19,20 @@ func (app *application) createListingHandler(w http.ResponseWriter, r *http.Requ
 		PropertyStatusId int64   `json:"property_status_id"`
 		PropertyTypeId   int64   `json:"property_type_id"`
 		Price            float64 `json:"price"`
+		Area             float64 `json:"area"`
 		Description      string  `json:"description"`
 		Address          string  `json:"address"`
 		DistrictId       int64   `json:"district_id"`
 		GoogleMapUrl     string  `json:"google_map_url"`
+
+		//rental terms, only used when listing_type is rent
+		ListingType        string  `json:"listing_type"`
+		RentPeriod         string  `json:"rent_period"`
+		Deposit            float64 `json:"deposit"`
+		MinimumLeaseMonths int32   `json:"minimum_lease_months"`
+		UtilitiesIncluded  bool    `json:"utilities_included"`
+		PetsAllowed        bool    `json:"pets_allowed"`
+		AvailableFrom      string  `json:"available_from"`
 	}
 
 	//initialize the new json decoder instance
@@ -39,15 +50,38 @@ func (app *application) createListingHandler(w http.ResponseWriter, r *http.Requ
 		PropertyStatusId: input.PropertyStatusId,
 		PropertyTypeId:   input.PropertyTypeId,
 		Price:            input.Price,
+		Area:             input.Area,
 		Description:      input.Description,
 		Address:          input.Address,
 		DistrictId:       input.DistrictId,
 		GoogleMapUrl:     input.GoogleMapUrl,
+		RentalTerms: data.RentalTerms{
+			ListingType:        input.ListingType,
+			RentPeriod:         input.RentPeriod,
+			Deposit:            input.Deposit,
+			MinimumLeaseMonths: input.MinimumLeaseMonths,
+			UtilitiesIncluded:  input.UtilitiesIncluded,
+			PetsAllowed:        input.PetsAllowed,
+		},
+	}
+
+	//listings are for sale unless told otherwise
+	if listing.ListingType == "" {
+		listing.ListingType = data.ListingTypeSale
 	}
 
 	//Initialize a new Validator instance
 	v := validator.New()
 
+	if input.AvailableFrom != "" {
+		availableFrom, err := time.Parse(dateLayout, input.AvailableFrom)
+		if err != nil {
+			v.AddError("available_from", "must be a date in the format YYYY-MM-DD")
+		} else {
+			listing.AvailableFrom = &availableFrom
+		}
+	}
+
 	//check the map to determine if there were any validation errors
 	if data.ValidateListing(v, listing); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -56,9 +90,10 @@ func (app *application) createListingHandler(w http.ResponseWriter, r *http.Requ
 
 	//create listing
 
-	err = app.models.Listing.Insert(listing)
+	err = app.models.Listing.Insert(listing, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	//create a location header for newly resource : listing
@@ -136,6 +171,11 @@ func (app *application) updateListingHandler(w http.ResponseWriter, r *http.Requ
 
 	}
 
+	//only the owning agent, their broker or an admin may edit the listing
+	if !app.allowListingEdit(w, r, listing.ID) {
+		return
+	}
+
 	//Create an input Struct to hold data read in from client
 
 	var input struct {
@@ -143,10 +183,19 @@ func (app *application) updateListingHandler(w http.ResponseWriter, r *http.Requ
 		PropertyStatusId *string  `json:"property_status_id"`
 		PropertyTypeId   *string  `json:"property_type_id"`
 		Price            *float64 `json:"price"`
+		Area             *float64 `json:"area"`
 		Description      *string  `json:"description"`
 		Address          *string  `json:"address"`
 		DistrictId       *string  `json:"district_id"`
 		GoogleMapUrl     *string  `json:"google_map_url"`
+
+		ListingType        *string  `json:"listing_type"`
+		RentPeriod         *string  `json:"rent_period"`
+		Deposit            *float64 `json:"deposit"`
+		MinimumLeaseMonths *int32   `json:"minimum_lease_months"`
+		UtilitiesIncluded  *bool    `json:"utilities_included"`
+		PetsAllowed        *bool    `json:"pets_allowed"`
+		AvailableFrom      *string  `json:"available_from"`
 	}
 	//intialize new json.decoder instance
 
@@ -177,6 +226,10 @@ func (app *application) updateListingHandler(w http.ResponseWriter, r *http.Requ
 		listing.Price = *input.Price
 	}
 
+	if input.Area != nil {
+		listing.Area = *input.Area
+	}
+
 	if input.Description != nil {
 
 		listing.Description = *input.Description
@@ -194,9 +247,50 @@ func (app *application) updateListingHandler(w http.ResponseWriter, r *http.Requ
 		listing.GoogleMapUrl = *input.GoogleMapUrl
 	}
 
+	//a listing that stops being a rental loses its rent terms
+	if input.ListingType != nil {
+		if *input.ListingType == data.ListingTypeSale && listing.IsRental() {
+			listing.RentalTerms = data.RentalTerms{}
+		}
+		listing.ListingType = *input.ListingType
+	}
+
+	if input.RentPeriod != nil {
+		listing.RentPeriod = *input.RentPeriod
+	}
+
+	if input.Deposit != nil {
+		listing.Deposit = *input.Deposit
+	}
+
+	if input.MinimumLeaseMonths != nil {
+		listing.MinimumLeaseMonths = *input.MinimumLeaseMonths
+	}
+
+	if input.UtilitiesIncluded != nil {
+		listing.UtilitiesIncluded = *input.UtilitiesIncluded
+	}
+
+	if input.PetsAllowed != nil {
+		listing.PetsAllowed = *input.PetsAllowed
+	}
+
 	//Initalize a new Validator
 	v := validator.New()
 
+	//an empty string clears the date
+	if input.AvailableFrom != nil {
+		listing.AvailableFrom = nil
+		if *input.AvailableFrom != "" {
+			availableFrom, err := time.Parse(dateLayout, *input.AvailableFrom)
+			if err != nil {
+				v.AddError("available_from", "must be a date in the format YYYY-MM-DD")
+			} else {
+				listing.AvailableFrom = &availableFrom
+			}
+		}
+	}
+
 	//check the map to determine if there were any validation errors
 
 	if data.ValidateListings(v, listing); !v.Valid() {
@@ -230,8 +324,7 @@ func (app *application) updateListingHandler(w http.ResponseWriter, r *http.Requ
 func (app *application) showAllListingHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		PropertyTitle string
-		DistrictId    string
+		data.ListingSearch
 		data.Filters
 	}
 
@@ -245,6 +338,22 @@ func (app *application) showAllListingHandler(w http.ResponseWriter, r *http.Req
 	input.PropertyTitle = app.readString(qs, "property_title", "")
 	input.DistrictId = app.readString(qs, "district_id", "")
 
+	//rental search
+	input.ListingType = app.readString(qs, "listing_type", "")
+	input.RentPeriod = app.readString(qs, "rent_period", "")
+	input.PetsAllowed = app.readOptionalBool(qs, "pets_allowed", v)
+	input.UtilitiesIncluded = app.readOptionalBool(qs, "utilities_included", v)
+	input.MinPrice = app.readFloat(qs, "min_price", 0, v)
+	input.MaxPrice = app.readFloat(qs, "max_price", 0, v)
+
+	//listings owned by an agency
+	input.OrganizationID = int64(app.readInt(qs, "organization_id", 0, v))
+
+	//only listings available on or before the date
+	if availableBy := app.readDate(qs, "available_by", time.Time{}, v); !availableBy.IsZero() {
+		input.AvailableBy = &availableBy
+	}
+
 	//get the page info
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
@@ -255,15 +364,25 @@ func (app *application) showAllListingHandler(w http.ResponseWriter, r *http.Req
 	//specific the allowed sortValues
 	input.Filters.SortList = []string{"id", "property_title", "district_id", "-id", "-property_title", "-district_id"}
 
+	//csv/xlsx downloads or json
+	format := app.readFormat(r, v)
+
 	//check for validation errors
 
+	data.ValidateListingSearch(v, input.ListingSearch)
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	if format != formatJSON {
+		app.exportListings(w, r, format, input.ListingSearch, input.Filters)
+		return
+	}
+
 	//get a listing of all properties
-	listings, metadata, err := app.models.Listing.ShowListings(input.PropertyTitle, input.DistrictId, input.Filters)
+	listings, metadata, err := app.models.Listing.ShowListings(input.ListingSearch, input.Filters)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -279,3 +398,38 @@ func (app *application) showAllListingHandler(w http.ResponseWriter, r *http.Req
 	}
 
 }
+
+// exportListings streams every listing matching the search as csv or xlsx
+func (app *application) exportListings(w http.ResponseWriter, r *http.Request, format string, search data.ListingSearch, filters data.Filters) {
+
+	header := []string{"id", "property_title", "property_status", "property_type", "price", "area", "address", "district",
+		"agent", "agent_phone", "agent_email", "listed_at", "days_on_market", "listing_type", "rent_period", "deposit",
+		"minimum_lease_months", "utilities_included", "pets_allowed", "available_from"}
+
+	tw, err := app.newTableWriter(w, format, "listings", header)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Listing.StreamListings(search, filters, func(listing *data.Listings) error {
+
+		var listedAt, daysOnMarket, availableFrom interface{}
+		if listing.ListedAt != nil {
+			listedAt = listing.ListedAt.Format(dateLayout)
+		}
+		if listing.DaysOnMarket != nil {
+			daysOnMarket = *listing.DaysOnMarket
+		}
+		if listing.AvailableFrom != nil {
+			availableFrom = listing.AvailableFrom.Format(dateLayout)
+		}
+
+		return tw.WriteRow(listing.ID, listing.PropertyTitle, listing.PropertyStatusId, listing.PropertyTypeId, listing.Price, listing.Area,
+			listing.Address, listing.DistrictId, listing.Agent, listing.AgentPhone, listing.AgentEmail, listedAt, daysOnMarket,
+			listing.ListingType, listing.RentPeriod, listing.Deposit, listing.MinimumLeaseMonths, listing.UtilitiesIncluded,
+			listing.PetsAllowed, availableFrom)
+	})
+
+	app.closeTableWriter(r, tw, err)
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -13,12 +14,21 @@ import (
 // upload listing images
 func (app *application) uploadListingImageHandler(w http.ResponseWriter, r *http.Request) {
 
-	//get the id for last listing created
+	//get the id for last listing the agent created
 
-	listing, err := app.models.ListingImages.GetByListingId()
+	listing, err := app.models.ListingImages.GetByListingId(app.contextGetUser(r).ID)
 
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.allowListingEdit(w, r, listing) {
 		return
 	}
 
@@ -0,0 +1,166 @@
+//Filename: cmd/api/lockout.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+var (
+	// a username gets 3 free tries, then a doubling wait, and is locked on the 10th failure
+	usernameLoginPolicy = data.LoginPolicy{
+		FreeAttempts: 3,
+		MaxDelay:     15 * time.Minute,
+		LockAfter:    10,
+		LockFor:      30 * time.Minute,
+		Window:       time.Hour,
+	}
+
+	// an address trying many usernames is slowed down but never locked, a shared
+	// office or mobile network should not lock everyone out
+	ipLoginPolicy = data.LoginPolicy{
+		FreeAttempts: 10,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
+// how long the unlock link in the lockout email works
+const unlockTokenTTL = 24 * time.Hour
+
+// loginKeys returns the failure counter keys of a login attempt
+func loginKeys(username string, r *http.Request) (string, string) {
+	return "user:" + strings.ToLower(strings.TrimSpace(username)), "ip:" + clientIP(r)
+}
+
+// beginLoginAttempt counts a login attempt against the address and the username before
+// the password or code is checked. It returns a throttle when either of them has to wait
+func (app *application) beginLoginAttempt(userKey, ipKey string) (*data.LoginThrottle, error) {
+
+	throttle, err := app.models.LoginFailures.Attempt(ipKey, ipLoginPolicy)
+	if err != nil || throttle != nil {
+		return throttle, err
+	}
+
+	return app.models.LoginFailures.Attempt(userKey, usernameLoginPolicy)
+}
+
+// endLoginAttempt takes a good attempt back from the address, the username is reset
+// once the session starts
+func (app *application) endLoginAttempt(ipKey string) error {
+	return app.models.LoginFailures.Forgive(ipKey, ipLoginPolicy)
+}
+
+// recordLoginFailure is called when a counted attempt failed. When it locks the username
+// and the username belongs to a user they are emailed an unlock link
+func (app *application) recordLoginFailure(r *http.Request, userKey string, user *data.User) error {
+
+	locked, err := app.models.LoginFailures.RecordFailure(userKey, usernameLoginPolicy)
+	if err != nil || !locked {
+		return err
+	}
+
+	entry := &data.AuditEntry{
+		Action:  data.AuditAccountLocked,
+		IP:      clientIP(r),
+		Details: map[string]string{"key": userKey},
+	}
+
+	if user == nil {
+		return app.models.Audit.Insert(entry)
+	}
+
+	entry.UserID = user.ID
+	err = app.models.Audit.Insert(entry)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, unlockTokenTTL, data.ScopeUnlock)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+
+		data := map[string]interface{}{
+			"unlockToken": token.Plaintext,
+			"minutes":     int(usernameLoginPolicy.LockFor.Minutes()),
+		}
+
+		err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	return nil
+}
+
+// unlock an account with the token from the lockout email
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeUnlock, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	userKey, _ := loginKeys(user.Username, r)
+
+	err = app.models.LoginFailures.Reset(userKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUsers(data.ScopeUnlock, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Audit.Insert(&data.AuditEntry{
+		UserID: user.ID,
+		Action: data.AuditAccountUnlocked,
+		IP:     clientIP(r),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
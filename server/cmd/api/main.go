@@ -6,6 +6,7 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -15,6 +16,8 @@ import (
 	"realestatebelize.imerlopez.net/internal/data"
 	"realestatebelize.imerlopez.net/internal/jsonlog"
 	"realestatebelize.imerlopez.net/internal/mailer"
+	"realestatebelize.imerlopez.net/internal/oidc"
+	"realestatebelize.imerlopez.net/internal/signedtoken"
 )
 
 // App Verison
@@ -45,16 +48,42 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	scheduler struct {
+		enabled bool
+	}
+	tokens struct {
+		accessTTL   time.Duration
+		refreshTTL  time.Duration
+		mode        string // database or signed
+		signingKeys string
+	}
+	mfa struct {
+		required bool
+	}
+	oidc struct {
+		providersFile string
+	}
+	exports struct {
+		dir string
+	}
+	publicURL string
 }
 
 //Dependency Injection
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config   config
+	logger   *jsonlog.Logger
+	models   data.Models
+	mailer   mailer.Mailer
+	wg       sync.WaitGroup
+	sessions lastUsed
+	signer   *signedtoken.Keys
+	denylist denylist
+	oidc     map[string]*oidc.Provider
+
+	apiKeysUsed    lastUsed
+	apiKeyLimiters apiKeyLimiters
 }
 
 func main() {
@@ -79,6 +108,25 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "8c073ce7c82892", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Belize RealEstate <no-reply@belizerealestate.imerlopez.net>", "SMTP Sender")
 
+	//flag for the report scheduler
+	flag.BoolVar(&cfg.scheduler.enabled, "scheduler-enabled", false, "Enable the scheduled report emails, turn on for one replica only")
+
+	//flags for how long sign ins last
+	flag.DurationVar(&cfg.tokens.accessTTL, "access-token-ttl", 15*time.Minute, "Authentication token lifetime")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	flag.StringVar(&cfg.tokens.mode, "token-mode", "database", "Authentication tokens: database or signed")
+	flag.StringVar(&cfg.tokens.signingKeys, "token-signing-keys", os.Getenv("REALESTATE_TOKEN_KEYS"), "Signed token keys as kid:base64key pairs, the first one signs")
+
+	//flag for the two-factor policy
+	flag.BoolVar(&cfg.mfa.required, "mfa-required", false, "Require two-factor authentication for users with listings:write")
+
+	//flag for the identity providers users can sign in with
+	flag.StringVar(&cfg.oidc.providersFile, "oidc-providers", os.Getenv("REALESTATE_OIDC_PROVIDERS"), "JSON file of OpenID Connect providers")
+
+	//flags for personal data exports, links in emails start with the public url
+	flag.StringVar(&cfg.exports.dir, "export-dir", "exports", "Folder personal data exports are written to")
+	flag.StringVar(&cfg.publicURL, "public-url", "", "Public URL of the API (default http://localhost:<port>)")
+
 	//use the flag.Func() function to parse our trusted origins flag from
 	//a string to a slice of string
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
@@ -87,8 +135,33 @@ func main() {
 	})
 	flag.Parse()
 
+	if cfg.publicURL == "" {
+		cfg.publicURL = fmt.Sprintf("http://localhost:%d", cfg.port)
+	}
+
 	//logger
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	//load the signing keys when access tokens are signed
+	var signer *signedtoken.Keys
+	switch cfg.tokens.mode {
+	case "database":
+	case "signed":
+		keys, err := signedtoken.ParseKeys(cfg.tokens.signingKeys)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		signer = keys
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown token mode %q", cfg.tokens.mode), nil)
+	}
+
+	//load the identity providers
+	providers, err := loadOIDCProviders(cfg.oidc.providersFile)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	//create connection pool
 	db, err := openDB(cfg)
 	if err != nil {
@@ -107,6 +180,8 @@ func main() {
 		logger: logger,
 		models: data.NewModels(db),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		signer: signer,
+		oidc:   providers,
 	}
 
 	// call the app.serve to start the server
@@ -0,0 +1,306 @@
+//Filename: cmd/api/me.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// meOr sends requests for /v1/users/me to me and the rest to other. The GET routes
+// share the /v1/users/:id path so the router cannot tell them apart
+func (app *application) meOr(me, other http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.readStringParam(r, "id") == "me" {
+			me(w, r)
+			return
+		}
+
+		other(w, r)
+	}
+}
+
+// writeProfile sends the signed in user their own account
+func (app *application) writeProfile(w http.ResponseWriter, r *http.Request, env envelope) {
+
+	userID := app.contextGetUser(r).ID
+
+	profile, err := app.models.Users.GetProfile(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	profile.Roles, err = app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//what this request may do, so an api key shows its scopes
+	profile.Permissions, err = app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	profile.Notifications, err = app.models.Notifications.GetForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if env == nil {
+		env = envelope{}
+	}
+	env["user"] = profile
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// show the signed in user their account
+func (app *application) showMeHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeProfile(w, r, nil)
+}
+
+// update the signed in user's account and notification preferences. A new email
+// address goes through the same confirmation as updateUserHandler
+func (app *application) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Username      *string `json:"username"`
+		Fullname      *string `json:"fullname"`
+		Email         *string `json:"email"`
+		Phone         *string `json:"phone"`
+		Address       *string `json:"address"`
+		DistrictId    *int64  `json:"district_id"`
+		UserTypeId    *int64  `json:"user_type_id"`
+		Notifications *struct {
+			ReportDigests *bool `json:"report_digests"`
+		} `json:"notifications"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Username != nil {
+		user.Username = *input.Username
+	}
+	if input.Fullname != nil {
+		user.Fullname = *input.Fullname
+	}
+	if input.Phone != nil {
+		user.Phone = *input.Phone
+	}
+	if input.Address != nil {
+		user.Address = *input.Address
+	}
+	if input.DistrictId != nil {
+		user.DistrictId = *input.DistrictId
+	}
+	if input.UserTypeId != nil {
+		user.UserTypeId = *input.UserTypeId
+	}
+
+	newEmail := ""
+	if input.Email != nil && *input.Email != user.Email {
+		newEmail = *input.Email
+	}
+
+	v := validator.New()
+
+	data.ValidateUser(v, user)
+	if newEmail != "" {
+		data.ValidateEmail(v, newEmail)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the profile is saved first so a username taken meanwhile does not leave a
+	//confirmation email behind
+	err = app.models.Users.UpdateProfile(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateUsername):
+			v.AddError("username", "a user with this username already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if newEmail != "" {
+		err = app.requestEmailChange(user.ID, newEmail)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateEmail):
+				v.AddError("email", "a user with this email address already exists")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	if input.Notifications != nil {
+		prefs, err := app.models.Notifications.GetForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if input.Notifications.ReportDigests != nil {
+			prefs.ReportDigests = *input.Notifications.ReportDigests
+		}
+
+		err = app.models.Notifications.Update(user.ID, prefs)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	var env envelope
+	if newEmail != "" {
+		env = envelope{"message": emailChangeMessage}
+	}
+
+	app.writeProfile(w, r, env)
+}
+
+// confirmOwner reads {"password", "code"} and checks them against the signed in user,
+// the code is only needed when two-factor authentication is on. It is asked for before
+// the account is closed or erased so a stolen session cannot do it
+func (app *application) confirmOwner(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return nil, false
+	}
+
+	mfaEnabled, err := app.models.MFA.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if mfaEnabled {
+		valid, err := app.verifySecondFactor(user.ID, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+
+		if !valid {
+			v.AddError("code", "invalid code")
+			app.failedValidationResponse(w, r, v.Errors)
+			return nil, false
+		}
+	}
+
+	return user, true
+}
+
+// close the signed in user's account
+func (app *application) deleteMeHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.confirmOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Users.Close(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//the tokens are gone from the database, signed ones have to be denied
+	err = app.denyUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Audit.Insert(&data.AuditEntry{
+		UserID: user.ID,
+		Action: data.AuditAccountClosed,
+		IP:     clientIP(r),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been closed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,354 @@
+//Filename: cmd/api/mfa.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/totp"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+const (
+	// the name authenticator apps show for our accounts
+	mfaIssuer = "Belize RealEstate"
+	// how long a user has to enter their code after their password
+	mfaTokenTTL = 5 * time.Minute
+	// wrong codes allowed against one mfa token before it is thrown away
+	maxMFAAttempts = 5
+)
+
+// mfaEnabled checks if the signed in user uses two-factor authentication
+func (app *application) mfaEnabled(r *http.Request) (bool, error) {
+
+	if claims := app.contextGetClaims(r); claims != nil {
+		return claims.MFA, nil
+	}
+
+	return app.models.MFA.IsEnabled(app.contextGetUser(r).ID)
+}
+
+// verifySecondFactor checks a code from the user's authenticator app or one of their
+// recovery codes, each can only be used once
+func (app *application) verifySecondFactor(userID int64, code string) (bool, error) {
+
+	mfa, err := app.models.MFA.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	if !mfa.Enabled {
+		return false, nil
+	}
+
+	if step, ok := totp.Validate(mfa.Secret, code, time.Now()); ok {
+		return app.models.MFA.UseStep(userID, step)
+	}
+
+	return app.models.MFA.UseRecoveryCode(userID, code)
+}
+
+// readMFACode reads the {"code"} body the two-factor endpoints take
+func (app *application) readMFACode(w http.ResponseWriter, r *http.Request) (string, bool) {
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return "", false
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+
+	return input.Code, true
+}
+
+// start two-factor enrollment, the secret is shown once as a provisioning URI for a QR code
+func (app *application) enrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.models.Users.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Enroll(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAEnabled):
+			app.mfaEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(mfaIssuer, user.Email, secret),
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirm enrollment with a first code, the recovery codes are only ever shown here
+func (app *application) confirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+
+	code, ok := app.readMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	v := validator.New()
+
+	mfa, err := app.models.MFA.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "two-factor enrollment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if mfa.Enabled {
+		app.mfaEnabledResponse(w, r)
+		return
+	}
+
+	step, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Enable(userID, step, recoveryCodes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAEnabled):
+			app.mfaEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// replace the recovery codes, for when they run low or may have leaked
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+
+	code, ok := app.readMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	valid, err := app.verifySecondFactor(userID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		v := validator.New()
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.ReplaceRecoveryCodes(userID, recoveryCodes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// turn two-factor authentication off, users the policy covers must keep it
+func (app *application) disableMFAHandler(w http.ResponseWriter, r *http.Request) {
+
+	code, ok := app.readMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	if app.config.mfa.required {
+		permissions, err := app.models.Permissions.GetAllForUser(userID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if permissions.Includes("listings:write") {
+			app.mfaRequiredResponse(w, r)
+			return
+		}
+	}
+
+	valid, err := app.verifySecondFactor(userID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		v := validator.New()
+		v.AddError("code", "invalid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.MFA.Disable(userID)
+	if err != nil && !errors.Is(err, data.ErrMFANotEnabled) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// the second step of signing in, swap the mfa token and a code for the session tokens
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlainText(v, input.MFAToken)
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFA, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//wrong codes count against the username like wrong passwords, so the
+	//backoff and lockout hold for the second factor too
+	userKey, ipKey := loginKeys(user.Username, r)
+
+	throttle, err := app.beginLoginAttempt(userKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if throttle != nil {
+		app.loginThrottledResponse(w, r, throttle)
+		return
+	}
+
+	valid, err := app.verifySecondFactor(user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !valid {
+		err = app.recordLoginFailure(r, userKey, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		//throw the token away after too many wrong codes so they cannot be guessed
+		attempts, err := app.models.Tokens.CountAttempt(input.MFAToken)
+		if err == nil && attempts >= maxMFAAttempts {
+			err = app.models.Tokens.DeleteByPlaintext(data.ScopeMFA, input.MFAToken)
+		}
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteByPlaintext(data.ScopeMFA, input.MFAToken)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.endLoginAttempt(ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeNewSession(w, r, user)
+}
@@ -13,6 +13,7 @@ import (
 
 	"golang.org/x/time/rate"
 	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/signedtoken"
 	"realestatebelize.imerlopez.net/internal/validator"
 )
 
@@ -103,6 +104,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		//a not to caches that response may vary
 
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		//scripts and partner portals sign in with an API key instead of a token
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			app.authenticateAPIKey(w, r, next, apiKey)
+			return
+		}
 
 		//Retrieve the value of the Authorization header from the request
 		authorizationHeader := r.Header.Get("Authorization")
@@ -125,6 +133,21 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		//Extra token
 		token := headerParts[1]
 
+		//signed tokens are checked without the database
+		if app.signer != nil && signedtoken.Looks(token) {
+			claims, err := app.signer.Verify(token, time.Now())
+			if err != nil || app.denylist.denied(claims) {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, &data.User{ID: claims.UserID, Activated: claims.Activated})
+			r = app.contextSetClaims(r, claims)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		//validate the token
 		v := validator.New()
 
@@ -146,8 +169,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 			return
 		}
+		//remember the use, it is saved with the next batch
+		hash := data.TokenHash(token)
+		app.sessions.touch(hash)
+
 		//add the user information to the request context
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, hash)
 
 		//call the next handler in the chain
 
@@ -192,13 +220,28 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// requireUserSession keeps API keys away from account settings such as sessions,
+// two-factor authentication and the keys themselves
+func (app *application) requireUserSession(next http.HandlerFunc) http.HandlerFunc {
+
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if app.contextGetAPIKey(r) != nil {
+			app.notPerrmittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireActivatedUser(fn)
+}
+
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//get the user
-		user := app.contextGetUser(r)
 		// get the permission for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.userPermissions(r)
 
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
@@ -212,6 +255,20 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 
 		}
 
+		//users who can change listings may be required to use two-factor authentication
+		if app.config.mfa.required && permissions.Includes("listings:write") {
+			enabled, err := app.mfaEnabled(r)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if !enabled {
+				app.mfaRequiredResponse(w, r)
+				return
+			}
+		}
+
 		//Ok
 		next.ServeHTTP(w, r)
 	})
@@ -0,0 +1,119 @@
+//Filename: cmd/api/mortgage.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/finance"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// the loan terms used when the client does not send their own
+const (
+	defaultMortgageRate        = 7.5
+	defaultMortgageTermYears   = 25
+	defaultDownPaymentFraction = 0.2
+)
+
+// show the monthly payment on a listing
+func (app *application) showListingMortgageHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//fetch the listing for its price
+	listing, err := app.models.Listing.GetPrice(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	data.ValidateSaleListing(v, listing.ListingType)
+
+	loan := finance.Loan{
+		Price:             listing.Price,
+		DownPayment:       app.readFloat(qs, "down_payment", listing.Price*defaultDownPaymentFraction, v),
+		AnnualRate:        app.readFloat(qs, "annual_rate", defaultMortgageRate, v),
+		TermYears:         app.readInt(qs, "term_years", defaultMortgageTermYears, v),
+		AnnualPropertyTax: app.readFloat(qs, "annual_property_tax", 0, v),
+		AnnualInsurance:   app.readFloat(qs, "annual_insurance", 0, v),
+	}
+
+	if finance.ValidateLoan(v, loan); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mortgage := finance.Calculate(loan, app.readString(qs, "schedule", "") == "true")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"listing_id": listing.ID, "mortgage": mortgage}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// calculate a mortgage and its full amortization schedule
+func (app *application) calculateMortgageHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Price             float64  `json:"price"`
+		DownPayment       *float64 `json:"down_payment"`
+		AnnualRate        *float64 `json:"annual_rate"`
+		TermYears         *int     `json:"term_years"`
+		AnnualPropertyTax float64  `json:"annual_property_tax"`
+		AnnualInsurance   float64  `json:"annual_insurance"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	loan := finance.Loan{
+		Price:             input.Price,
+		DownPayment:       input.Price * defaultDownPaymentFraction,
+		AnnualRate:        defaultMortgageRate,
+		TermYears:         defaultMortgageTermYears,
+		AnnualPropertyTax: input.AnnualPropertyTax,
+		AnnualInsurance:   input.AnnualInsurance,
+	}
+
+	if input.DownPayment != nil {
+		loan.DownPayment = *input.DownPayment
+	}
+
+	if input.AnnualRate != nil {
+		loan.AnnualRate = *input.AnnualRate
+	}
+
+	if input.TermYears != nil {
+		loan.TermYears = *input.TermYears
+	}
+
+	v := validator.New()
+
+	if finance.ValidateLoan(v, loan); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"mortgage": finance.Calculate(loan, true)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,353 @@
+//Filename: cmd/api/oidc.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/oidc"
+)
+
+// how long a user has to finish signing in at the provider
+const oidcStateTTL = 10 * time.Minute
+
+// the cookie that ties a sign in to the browser that started it, so a callback link
+// sent to someone else cannot sign them in to the sender's account
+const (
+	oidcBindingCookie = "oidc_binding"
+	oidcBindingPath   = "/v1/oidc/"
+)
+
+// setOIDCBinding gives the browser a new binding cookie and returns its value
+func setOIDCBinding(w http.ResponseWriter) (string, error) {
+
+	binding, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    binding,
+		Path:     oidcBindingPath,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		//the provider sends the user back with a top level GET, which lax cookies go along with
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return binding, nil
+}
+
+// oidcBinding returns the binding cookie of the request, empty when there is none
+func oidcBinding(r *http.Request) string {
+
+	cookie, err := r.Cookie(oidcBindingCookie)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+// clearOIDCBinding removes the binding cookie, it works for one callback
+func clearOIDCBinding(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Path:     oidcBindingPath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// loadOIDCProviders reads the identity providers from the config file, there are
+// none when no file is given
+func loadOIDCProviders(path string) (map[string]*oidc.Provider, error) {
+
+	providers := make(map[string]*oidc.Provider)
+	if path == "" {
+		return providers, nil
+	}
+
+	configs, err := oidc.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range configs {
+		providers[c.Name] = oidc.New(c, nil)
+	}
+
+	return providers, nil
+}
+
+// oidcProvider returns the provider named in the url
+func (app *application) oidcProvider(r *http.Request) (*oidc.Provider, bool) {
+	provider, ok := app.oidc[app.readStringParam(r, "provider")]
+	return provider, ok
+}
+
+// startOIDC saves a new sign in for the provider, bound to the browser of the request,
+// and returns the url to send the user to
+func (app *application) startOIDC(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, linkUserID *int64) (string, error) {
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+
+	//get the url first so a provider that is down does not leave a cookie behind
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	binding, err := setOIDCBinding(w)
+	if err != nil {
+		return "", err
+	}
+
+	err = app.models.Identities.InsertState(state, &data.OIDCState{
+		Provider: provider.Config().Name,
+		Nonce:    nonce,
+		Verifier: verifier,
+		UserID:   linkUserID,
+		Binding:  binding,
+	}, oidcStateTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return authURL, nil
+}
+
+// start a sign in with an identity provider
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+
+	provider, ok := app.oidcProvider(r)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	authURL, err := app.startOIDC(w, r, provider, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// start linking an identity provider to the signed in user
+func (app *application) linkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+
+	provider, ok := app.oidcProvider(r)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	authURL, err := app.startOIDC(w, r, provider, &userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// the provider sends the user back here with a code. A link started by a signed in
+// user is saved, otherwise the linked user is signed in. Identities that are not linked
+// get a new activated account when the provider allows sign ups and the email is
+// verified and in an allowed domain
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+
+	provider, ok := app.oidcProvider(r)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+	code := qs.Get("code")
+	stateParam := qs.Get("state")
+
+	//only the browser that started the sign in can finish it
+	binding := oidcBinding(r)
+	clearOIDCBinding(w)
+
+	if stateParam == "" || binding == "" {
+		app.oidcSignInFailedResponse(w, r)
+		return
+	}
+
+	//the state is used up even when the user cancelled at the provider
+	state, err := app.models.Identities.ConsumeState(stateParam, provider.Config().Name, binding)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidOIDCState):
+			app.oidcSignInFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if code == "" {
+		app.oidcSignInFailedResponse(w, r)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), code, state.Verifier, state.Nonce)
+	if err != nil {
+		app.logger.PrintInfo("oidc sign in failed", map[string]string{"provider": provider.Config().Name, "error": err.Error()})
+		app.oidcSignInFailedResponse(w, r)
+		return
+	}
+
+	identity := &data.Identity{
+		Provider: provider.Config().Name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	if state.UserID != nil {
+		identity.UserID = *state.UserID
+
+		err = app.models.Identities.Link(identity)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateIdentity):
+				app.errorResponse(w, r, http.StatusConflict, "this identity or provider is already linked to an account")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusCreated, envelope{"identity": identity}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Identities.GetUser(identity.Provider, identity.Subject)
+	switch {
+	case err == nil:
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		app.beginSession(w, r, user)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	config := provider.Config()
+	if !oidcSignupAllowed(config, claims) {
+		app.oidcNoAccountResponse(w, r)
+		return
+	}
+
+	fullname := claims.Name
+	if fullname == "" {
+		fullname = claims.Email
+	}
+
+	user = &data.User{
+		Fullname:   fullname,
+		Email:      claims.Email,
+		DistrictId: config.DistrictID,
+		UserTypeId: config.UserTypeID,
+	}
+
+	//accounts that already use the email have to sign in and link the identity
+	//themselves, otherwise anyone at the provider could take them over
+	err = app.models.Identities.CreateUser(user, identity, data.RoleBuyer)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.errorResponse(w, r, http.StatusConflict, "an account with this email address already exists, sign in and link this provider to it")
+		case errors.Is(err, data.ErrDuplicateIdentity):
+			app.oidcSignInFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeNewSession(w, r, user)
+}
+
+// oidcSignupAllowed reports whether an identity that is not linked yet may get a new
+// account, the provider has to allow sign ups and vouch for an email in an allowed domain
+func oidcSignupAllowed(config oidc.Config, claims *oidc.Claims) bool {
+	return config.AllowSignup && claims.Email != "" && claims.EmailVerified && config.AllowsEmail(claims.Email)
+}
+
+// list the identity providers linked to the signed in user
+func (app *application) listIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+
+	if app.readStringParam(r, "id") != "me" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"identities": identities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unlink an identity provider from the signed in user
+func (app *application) unlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+
+	err := app.models.Identities.Delete(app.contextGetUser(r).ID, app.readStringParam(r, "provider"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "identity unlinked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
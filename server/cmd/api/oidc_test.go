@@ -0,0 +1,143 @@
+//Filename: cmd/api/oidc_test.go
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"realestatebelize.imerlopez.net/internal/oidc"
+)
+
+func TestOIDCBindingCookie(t *testing.T) {
+
+	rr := httptest.NewRecorder()
+
+	binding, err := setOIDCBinding(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+
+	c := cookies[0]
+	if c.Name != oidcBindingCookie || c.Value != binding || c.Path != oidcBindingPath {
+		t.Errorf("unexpected cookie %+v", c)
+	}
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.MaxAge != int(oidcStateTTL.Seconds()) {
+		t.Errorf("the cookie is missing its protections: %+v", c)
+	}
+
+	//every sign in gets its own binding
+	other, err := setOIDCBinding(httptest.NewRecorder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == binding {
+		t.Error("two sign ins got the same binding")
+	}
+
+	//the browser sends it back on the callback
+	r := httptest.NewRequest(http.MethodGet, "/v1/oidc/acme/callback?code=c&state=s", nil)
+	r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+
+	if got := oidcBinding(r); got != binding {
+		t.Errorf("got binding %q, want %q", got, binding)
+	}
+
+	if got := oidcBinding(httptest.NewRequest(http.MethodGet, "/v1/oidc/acme/callback", nil)); got != "" {
+		t.Errorf("got binding %q without a cookie", got)
+	}
+}
+
+// callback runs the callback handler for the provider without a database, the
+// requests it is given must be turned away before the state is looked up
+func callback(t *testing.T, provider, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	app := &application{
+		oidc: map[string]*oidc.Provider{
+			"acme": oidc.New(oidc.Config{Name: "acme", Issuer: "https://login.acme.bz", ClientID: "client"}, nil),
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "provider", Value: provider}}))
+
+	rr := httptest.NewRecorder()
+	app.oidcCallbackHandler(rr, r)
+
+	return rr
+}
+
+func TestOIDCCallbackNeedsTheStartingBrowser(t *testing.T) {
+
+	binding := &http.Cookie{Name: oidcBindingCookie, Value: "the-binding"}
+
+	tests := []struct {
+		name     string
+		provider string
+		target   string
+		cookies  []*http.Cookie
+		status   int
+	}{
+		{"unknown provider", "nope", "/v1/oidc/nope/callback?code=c&state=s", []*http.Cookie{binding}, http.StatusNotFound},
+		{"callback link opened in another browser", "acme", "/v1/oidc/acme/callback?code=c&state=s", nil, http.StatusUnauthorized},
+		{"empty binding cookie", "acme", "/v1/oidc/acme/callback?code=c&state=s", []*http.Cookie{{Name: oidcBindingCookie, Value: ""}}, http.StatusUnauthorized},
+		{"no state", "acme", "/v1/oidc/acme/callback?code=c", []*http.Cookie{binding}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			rr := callback(t, tt.provider, tt.target, tt.cookies...)
+
+			if rr.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.status, rr.Body.String())
+			}
+		})
+	}
+
+	//the binding works for one callback, the browser is told to drop it
+	rr := callback(t, "acme", "/v1/oidc/acme/callback?code=c", binding)
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcBindingCookie || cookies[0].MaxAge >= 0 || cookies[0].Path != oidcBindingPath {
+		t.Fatalf("the binding cookie was not cleared: %+v", cookies)
+	}
+}
+
+func TestOIDCSignupAllowed(t *testing.T) {
+
+	open := oidc.Config{AllowSignup: true}
+	acmeOnly := oidc.Config{AllowSignup: true, AllowedDomains: []string{"acme.bz"}}
+
+	tests := []struct {
+		name    string
+		config  oidc.Config
+		claims  oidc.Claims
+		allowed bool
+	}{
+		{"verified email", open, oidc.Claims{Email: "jane@acme.bz", EmailVerified: true}, true},
+		{"verified email in an allowed domain", acmeOnly, oidc.Claims{Email: "jane@acme.bz", EmailVerified: true}, true},
+		{"sign ups turned off", oidc.Config{}, oidc.Claims{Email: "jane@acme.bz", EmailVerified: true}, false},
+		{"email not verified", open, oidc.Claims{Email: "jane@acme.bz"}, false},
+		{"no email", open, oidc.Claims{EmailVerified: true}, false},
+		{"domain not allowed", acmeOnly, oidc.Claims{Email: "jane@evil.bz", EmailVerified: true}, false},
+	}
+
+	for _, tt := range tests {
+		if got := oidcSignupAllowed(tt.config, &tt.claims); got != tt.allowed {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+//Filename: cmd/api/organizations.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// how long an agent has to accept an invitation to an agency
+const orgInvitationTTL = 7 * 24 * time.Hour
+
+// requireOrganizationManager checks that the user is a broker or admin of the organization
+// in the route and writes the error response when they are not
+func (app *application) requireOrganizationManager(w http.ResponseWriter, r *http.Request) (int64, bool) {
+
+	orgID, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return 0, false
+	}
+
+	user := app.contextGetUser(r)
+
+	member, err := app.models.Organizations.GetMember(orgID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notPerrmittedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return 0, false
+	}
+
+	if !member.CanManage() {
+		app.notPerrmittedResponse(w, r)
+		return 0, false
+	}
+
+	return orgID, true
+}
+
+// create an agency, the user creating it becomes its broker
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name        string `json:"name"`
+		Email       string `json:"email"`
+		Phone       string `json:"phone"`
+		Address     string `json:"address"`
+		Website     string `json:"website"`
+		Description string `json:"description"`
+		DistrictId  *int64 `json:"district_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	org := &data.Organization{
+		Name:        input.Name,
+		Slug:        data.Slugify(input.Name),
+		Email:       input.Email,
+		Phone:       input.Phone,
+		Address:     input.Address,
+		Website:     input.Website,
+		Description: input.Description,
+		DistrictId:  input.DistrictId,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Insert(org, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateOrganization):
+			v.AddError("name", "an agency with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAlreadyMember):
+			v.AddError("user", "you already belong to an agency")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/organizations/%d", org.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": org}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the agencies
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "name")
+	input.Filters.SortList = []string{"id", "name", "-id", "-name"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orgs, metadata, err := app.models.Organizations.GetAll(input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organizations": orgs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// the agency profile page: its details, its agents and its headline numbers,
+// the listings are at /v1/listings?organization_id=
+func (app *application) showOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	org, err := app.models.Organizations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	members, err := app.models.Organizations.GetMembers(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stats, err := app.models.Organizations.GetStats(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": org, "members": members, "stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// update the agency profile
+func (app *application) updateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.requireOrganizationManager(w, r)
+	if !ok {
+		return
+	}
+
+	org, err := app.models.Organizations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Email       *string `json:"email"`
+		Phone       *string `json:"phone"`
+		Address     *string `json:"address"`
+		Website     *string `json:"website"`
+		Description *string `json:"description"`
+		DistrictId  *int64  `json:"district_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		org.Name = *input.Name
+		org.Slug = data.Slugify(*input.Name)
+	}
+
+	if input.Email != nil {
+		org.Email = *input.Email
+	}
+
+	if input.Phone != nil {
+		org.Phone = *input.Phone
+	}
+
+	if input.Address != nil {
+		org.Address = *input.Address
+	}
+
+	if input.Website != nil {
+		org.Website = *input.Website
+	}
+
+	if input.Description != nil {
+		org.Description = *input.Description
+	}
+
+	if input.DistrictId != nil {
+		org.DistrictId = input.DistrictId
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Update(org)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateOrganization):
+			v.AddError("name", "an agency with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": org}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// invite an agent to the agency, they only become a member once they accept
+func (app *application) inviteOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.requireOrganizationManager(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	//members join as agents unless told otherwise
+	if input.Role == "" {
+		input.Role = data.OrgRoleAgent
+	}
+
+	v := validator.New()
+
+	v.Check(input.Username != "", "username", "must be provided")
+	if data.ValidateOrganizationRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invitation, err := app.models.Organizations.InviteMember(id, input.Username, input.Role, orgInvitationTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("username", "no user with this username")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrAlreadyMember):
+			v.AddError("username", "this user already belongs to an agency")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+
+		data := map[string]interface{}{
+			"organizationName": invitation.OrganizationName,
+			"organizationID":   invitation.OrganizationID,
+			"role":             invitation.Role,
+		}
+
+		err := app.mailer.Send(invitation.Email, "organization_invitation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the agencies that invited the signed in user
+func (app *application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if app.readStringParam(r, "id") != "me" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	invitations, err := app.models.Organizations.GetInvitationsForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// accept an agency's invitation, the signed in user joins it
+func (app *application) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+
+	orgID, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	member, err := app.models.Organizations.AcceptInvitation(orgID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAlreadyMember):
+			app.errorResponse(w, r, http.StatusConflict, "you already belong to an agency, leave it before joining another")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// decline an agency's invitation
+func (app *application) declineInvitationHandler(w http.ResponseWriter, r *http.Request) {
+
+	orgID, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Organizations.DeclineInvitation(orgID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invitation declined"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// change the role of a member
+func (app *application) updateOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.requireOrganizationManager(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readNamedIdParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	member, err := app.models.Organizations.GetMember(id, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganizationRole(v, input.Role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.UpdateMemberRole(member, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastBroker):
+			v.AddError("role", "the agency must keep at least one broker")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// remove an agent from the agency, their listings are handed to ?reassign_to=<user id>.
+// Members may remove themselves, anyone else needs a broker or admin
+func (app *application) removeOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := app.readNamedIdParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if userID != app.contextGetUser(r).ID {
+		if _, ok := app.requireOrganizationManager(w, r); !ok {
+			return
+		}
+	}
+
+	v := validator.New()
+
+	reassignTo := int64(app.readInt(r.URL.Query(), "reassign_to", 0, v))
+	v.Check(reassignTo >= 0, "reassign_to", "must be a valid user id")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reassigned, err := app.models.Organizations.RemoveMember(id, userID, reassignTo)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastBroker):
+			v.AddError("user_id", "the agency must keep at least one broker")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrListingsNotReassigned), errors.Is(err, data.ErrNotMember):
+			v.AddError("reassign_to", "must be another member of the agency to hand this agent's listings to")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member successfully removed", "listings_reassigned": reassigned}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// make the agency the owner of a listing and assign it to one of its agents
+func (app *application) assignOrganizationListingHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.requireOrganizationManager(w, r)
+	if !ok {
+		return
+	}
+
+	listingID, err := app.readNamedIdParam(r, "listing_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		AgentID int64 `json:"agent_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.AgentID > 0, "agent_id", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.AssignListing(id, listingID, input.AgentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNotMember):
+			v.AddError("agent_id", "must be a member of the agency")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrListingAgentNotMember):
+			v.AddError("listing_id", "must be assigned to an agent of the agency")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrListingOwned):
+			app.notPerrmittedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization_id": id, "listing_id": listingID, "agent_id": input.AgentID}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
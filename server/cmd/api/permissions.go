@@ -0,0 +1,273 @@
+//Filename: cmd/api/permissions.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// the permission that lets an admin manage grants, admins may not take it from themselves
+const permissionsWrite = "permissions:write"
+
+// list every permission code
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the roles and the permissions each one grants
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readGrantUser reads the user id of the route and checks the user exists
+func (app *application) readGrantUser(w http.ResponseWriter, r *http.Request) (int64, bool) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return 0, false
+	}
+
+	_, err = app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return 0, false
+	}
+
+	return id, true
+}
+
+// writeUserGrants responds with the roles and permissions of a user
+func (app *application) writeUserGrants(w http.ResponseWriter, r *http.Request, userID int64) {
+
+	roles, err := app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"user_id":            userID,
+		"roles":              roles,
+		"direct_permissions": direct,
+		"permissions":        permissions,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantsChanged revokes the signed tokens that still carry the user's old permissions,
+// the user refreshes to get the new ones, then writes the grants
+func (app *application) grantsChanged(w http.ResponseWriter, r *http.Request, userID int64) {
+
+	err := app.denyUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserGrants(w, r, userID)
+}
+
+// show the roles, direct permissions and effective permissions of a user
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.readGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserGrants(w, r, id)
+}
+
+// grant permissions to a user directly
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.readGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Codes) > 0, "codes", "must contain at least one permission")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+	for _, code := range input.Codes {
+		v.Check(known.Includes(code), "codes", "contains an unknown permission: "+code)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(id, input.Codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.grantsChanged(w, r, id)
+}
+
+// revoke a permission granted to a user directly
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.readGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	code := app.readStringParam(r, "code")
+
+	//stop an admin locking themselves out
+	if id == app.contextGetUser(r).ID && code == permissionsWrite {
+		v := validator.New()
+		v.AddError("code", "you cannot revoke your own "+permissionsWrite+" permission")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Permissions.RemoveForUser(id, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.grantsChanged(w, r, id)
+}
+
+// give roles to a user
+func (app *application) grantUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.readGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Roles) > 0, "roles", "must contain at least one role")
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+	for _, role := range input.Roles {
+		v.Check(validator.In(role, data.RoleBuyer, data.RoleAgent, data.RoleBroker, data.RoleAdmin), "roles", "contains an unknown role: "+role)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.AddForUser(id, input.Roles...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.grantsChanged(w, r, id)
+}
+
+// take a role away from a user
+func (app *application) revokeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.readGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	role := app.readStringParam(r, "role")
+
+	//stop an admin locking themselves out
+	if id == app.contextGetUser(r).ID && role == data.RoleAdmin {
+		v := validator.New()
+		v.AddError("role", "you cannot remove your own admin role")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.models.Roles.RemoveForUser(id, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.grantsChanged(w, r, id)
+}
@@ -0,0 +1,375 @@
+//Filename: cmd/api/personaldata.go
+
+package main
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+const (
+	// how long the download link of an export works
+	dataExportTTL = 24 * time.Hour
+	// a user can ask for one export in this time
+	dataExportGap = time.Hour
+)
+
+// ask for a zip of everything we hold about the signed in user. It is built in the
+// background and a single use download link is emailed to them
+func (app *application) requestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := app.contextGetUser(r).ID
+
+	last, err := app.models.DataExports.LastRequested(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if last != nil && time.Since(*last) < dataExportGap {
+		app.dataExportThrottledResponse(w, r, dataExportGap-time.Since(*last))
+		return
+	}
+
+	//exports nobody downloaded are cleaned up as new ones are asked for
+	expired, err := app.models.DataExports.DeleteExpired()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.removeFiles(expired)
+
+	err = os.MkdirAll(app.config.exports.dir, 0700)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	name := make([]byte, 16)
+	_, err = rand.Read(name)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	path := filepath.Join(app.config.exports.dir, hex.EncodeToString(name)+".zip")
+
+	export, err := app.models.DataExports.New(userID, path, dataExportTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+
+		user, err := app.models.Users.GetByID(userID)
+		if err == nil {
+			err = app.writePersonalData(user.ID, path)
+		}
+
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"user_id": fmt.Sprint(userID)})
+			os.Remove(path)
+			if err := app.models.DataExports.Delete(export.ID); err != nil {
+				app.logger.PrintError(err, nil)
+			}
+			return
+		}
+
+		data := map[string]interface{}{
+			"downloadURL": strings.TrimSuffix(app.config.publicURL, "/") + "/v1/exports/" + export.Plaintext,
+		}
+
+		err = app.mailer.Send(user.Email, "data_export.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "your data export is being prepared, a download link will be emailed to you"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writePersonalData writes the zip of the user's profile, sessions, linked identities,
+// api keys, bookings, audit log entries and uploaded images to path
+func (app *application) writePersonalData(userID int64, path string) error {
+
+	profile, err := app.models.Users.GetProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	profile.Roles, err = app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	profile.Permissions, err = app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	profile.Notifications, err = app.models.Notifications.GetForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := app.models.Tokens.GetSessions(userID, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	//only the user's own keys, not the other keys of their agency
+	keys, err := app.models.APIKeys.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+	ownKeys := []*data.APIKey{}
+	for _, key := range keys {
+		if key.UserID == userID {
+			ownKeys = append(ownKeys, key)
+		}
+	}
+
+	bookings, err := app.models.Bookings.GetAllForGuest(userID)
+	if err != nil {
+		return err
+	}
+
+	audit, err := app.models.Audit.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	images, err := app.models.UserProfileImage.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{"profile.json", profile},
+		{"sessions.json", sessions},
+		{"identities.json", identities},
+		{"api_keys.json", ownKeys},
+		{"bookings.json", bookings},
+		{"audit_log.json", audit},
+	}
+
+	for _, file := range files {
+		b, err := json.MarshalIndent(file.value, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+
+		_, err = fw.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, image := range images {
+		err = addUpload(zw, image)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// addUpload copies an uploaded file into the zip, files that are gone are skipped
+func addUpload(zw *zip.Writer, path string) error {
+
+	//only ever read from the uploads folder
+	path = filepath.Clean(path)
+	if !strings.HasPrefix(path, "uploads"+string(filepath.Separator)) {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer src.Close()
+
+	fw, err := zw.Create("images/" + filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, src)
+	return err
+}
+
+// removeFiles deletes files that are no longer needed, failures are only logged
+func (app *application) removeFiles(paths []string) {
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
+
+// download an export with the link from the email, the link works once
+func (app *application) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+
+	token := app.readStringParam(r, "token")
+
+	v := validator.New()
+	if data.ValidateTokenPlainText(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	path, err := app.models.DataExports.Consume(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			//an expired link still has its file cleaned up
+			if path != "" {
+				app.removeFiles([]string{path})
+			}
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer app.removeFiles([]string{path})
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="personal-data.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	_, err = io.Copy(w, f)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// erase the signed in user's personal data, it takes the password like closing the account
+func (app *application) eraseMeHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.confirmOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.eraseUser(user.ID, "self")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account and personal data have been erased"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// erase a user's personal data on their behalf, for requests that come in by other
+// means or for accounts that were closed already
+func (app *application) eraseUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.eraseUser(id, fmt.Sprintf("admin:%d", app.contextGetUser(r).ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "the user's personal data has been erased"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// eraseUser anonymizes the user, signs them out everywhere and removes their uploads
+func (app *application) eraseUser(userID int64, by string) error {
+
+	files, err := app.models.Users.Erase(userID)
+	if err != nil {
+		return err
+	}
+
+	app.removeFiles(files)
+
+	err = app.denyUser(userID)
+	if err != nil {
+		return err
+	}
+
+	//no address is kept, the entry only records that it happened
+	return app.models.Audit.Insert(&data.AuditEntry{
+		UserID:  userID,
+		Action:  data.AuditAccountErased,
+		Details: map[string]string{"by": by},
+	})
+}
@@ -3,20 +3,103 @@ package main
 
 import (
 	"net/http"
+	"net/url"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// the columns of each report when it is written out as a table
+var (
+	topAgentsHeader      = []string{"agent_id", "agent_name", "profile_image", "total_property_sold", "total_sales", "avg_days_on_market"}
+	listingStatusHeader  = []string{"group", "sold_leased", "available"}
+	totalSalesHeader     = []string{"group", "total_sales", "total_list_price", "total_closed", "list_to_sale_ratio"}
+	inventoryAgingHeader = []string{"district", "property_type", "active_listings", "median_days_on_market", "days_0_30", "days_31_90", "days_90_plus"}
 )
 
+func topAgentsRow(a *data.TopAgents) []interface{} {
+	return []interface{}{a.AgentID, a.AgentName, a.ProfileImage, a.TotalPropertySold, a.TotalSales, a.AvgDaysOnMarket}
+}
+
+func listingStatusRow(s *data.ListingsStatus) []interface{} {
+	return []interface{}{s.Group, s.SoldLeased, s.Available}
+}
+
+func totalSalesRow(s *data.TotalSales) []interface{} {
+	return []interface{}{s.Group, s.TotalSales, s.TotalListPrice, s.TotalClosed, s.ListToSaleRatio}
+}
+
+func inventoryAgingRow(a *data.InventoryAging) []interface{} {
+	return []interface{}{a.District, a.PropertyType, a.ActiveListings, a.MedianDaysOnMarket, a.Days0To30, a.Days31To90, a.Days90Plus}
+}
+
+// readReportFilters reads the from, to and group_by query parameters shared by the reports
+func (app *application) readReportFilters(qs url.Values, v *validator.Validator) data.ReportFilters {
+
+	var filters data.ReportFilters
+
+	filters.From = app.readDate(qs, "from", time.Time{}, v)
+	filters.To = app.readDate(qs, "to", time.Time{}, v)
+	filters.OrganizationID = int64(app.readInt(qs, "organization_id", 0, v))
+	filters.GroupBy = app.readString(qs, "group_by", "")
+
+	//specific the allowed group by values
+	filters.GroupByList = []string{"month", "quarter", "district", "property_type", "agent", "organization"}
+
+	return filters
+}
+
 //getTopAgentsHandler allow client to see a top agents
 
 func (app *application) getTopAgentsHandler(w http.ResponseWriter, r *http.Request) {
 
+	//Initialize a validator
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters := data.TopAgentsFilters{
+		ReportFilters: app.readReportFilters(qs, v),
+		RankBy:        app.readString(qs, "rank_by", "volume"),
+		Limit:         app.readInt(qs, "limit", 5, v),
+	}
+
+	//specific the allowed rank by values
+	filters.RankByList = []string{"units", "volume", "days_on_market"}
+
+	format := app.readFormat(r, v)
+
+	//check for validation errors
+	if data.ValidateTopAgentsFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	//get a listing of the tog agents
-	agents, err := app.models.TopAgents.GetTopAgents()
+	agents, err := app.models.TopAgents.GetTopAgents(filters)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if format != formatJSON {
+		tw, err := app.newTableWriter(w, format, "top-agents", topAgentsHeader)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, agent := range agents {
+			err = tw.WriteRow(topAgentsRow(agent)...)
+			if err != nil {
+				break
+			}
+		}
+		app.closeTableWriter(r, tw, err)
+		return
+	}
+
 	//send a json response
 	err = app.writeJSON(w, http.StatusOK, envelope{"top_agents": agents}, nil)
 
@@ -30,14 +113,42 @@ func (app *application) getTopAgentsHandler(w http.ResponseWriter, r *http.Reque
 
 func (app *application) getListingStatusHandler(w http.ResponseWriter, r *http.Request) {
 
+	//Initialize a validator
+	v := validator.New()
+
+	filters := app.readReportFilters(r.URL.Query(), v)
+	format := app.readFormat(r, v)
+
+	//check for validation errors
+	if data.ValidateReportFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	//get a listing of the properties
-	listing, err := app.models.ListingsStatus.GetListingStatus()
+	listing, err := app.models.ListingsStatus.GetListingStatus(filters)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if format != formatJSON {
+		tw, err := app.newTableWriter(w, format, "listing-status", listingStatusHeader)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, status := range listing {
+			err = tw.WriteRow(listingStatusRow(status)...)
+			if err != nil {
+				break
+			}
+		}
+		app.closeTableWriter(r, tw, err)
+		return
+	}
+
 	//send a json response
 	err = app.writeJSON(w, http.StatusOK, envelope{"listing_status": listing}, nil)
 
@@ -51,14 +162,42 @@ func (app *application) getListingStatusHandler(w http.ResponseWriter, r *http.R
 
 func (app *application) getTotalSalesHandler(w http.ResponseWriter, r *http.Request) {
 
+	//Initialize a validator
+	v := validator.New()
+
+	filters := app.readReportFilters(r.URL.Query(), v)
+	format := app.readFormat(r, v)
+
+	//check for validation errors
+	if data.ValidateReportFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	//get a listing of the properties
-	sales, err := app.models.TotalSales.GetTotalSales()
+	sales, err := app.models.TotalSales.GetTotalSales(filters)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if format != formatJSON {
+		tw, err := app.newTableWriter(w, format, "total-sales", totalSalesHeader)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, sale := range sales {
+			err = tw.WriteRow(totalSalesRow(sale)...)
+			if err != nil {
+				break
+			}
+		}
+		app.closeTableWriter(r, tw, err)
+		return
+	}
+
 	//send a json response
 	err = app.writeJSON(w, http.StatusOK, envelope{"sales": sales}, nil)
 
@@ -67,3 +206,55 @@ func (app *application) getTotalSalesHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 }
+
+//getInventoryAgingHandler allow client to see how long the available listings have been on the market
+
+func (app *application) getInventoryAgingHandler(w http.ResponseWriter, r *http.Request) {
+
+	//Initialize a validator
+	v := validator.New()
+
+	//the aging report can be narrowed to one agency
+	filters := data.ReportFilters{
+		OrganizationID: int64(app.readInt(r.URL.Query(), "organization_id", 0, v)),
+	}
+
+	format := app.readFormat(r, v)
+
+	if data.ValidateReportFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//get the aging of the available listings
+	aging, err := app.models.InventoryAging.GetInventoryAging(filters)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if format != formatJSON {
+		tw, err := app.newTableWriter(w, format, "inventory-aging", inventoryAgingHeader)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, a := range aging {
+			err = tw.WriteRow(inventoryAgingRow(a)...)
+			if err != nil {
+				break
+			}
+		}
+		app.closeTableWriter(r, tw, err)
+		return
+	}
+
+	//send a json response
+	err = app.writeJSON(w, http.StatusOK, envelope{"inventory_aging": aging}, nil)
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
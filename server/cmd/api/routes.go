@@ -17,35 +17,114 @@ func (app *application) routes() http.Handler {
 	// File Server Route
 	router.ServeFiles("/uploads/*filepath", http.Dir("uploads"))
 
-	//Users routes
+	//Public routes - everything else needs a permission
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.getUserByIdHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activatedUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/users/image", app.uploadUserImageHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/image/update", app.updateUserImageHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/updated/:id", app.updateUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/users/resetpassword", app.resetPasswordHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/unlock", app.unlockUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/callback", app.oidcCallbackHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/exports/:token", app.downloadDataExportHandler)
+
+	//Users routes
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.meOr(app.requireActivatedUser(app.showMeHandler), app.requirePermission("users:read", app.getUserByIdHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireUserSession(app.updateMeHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireUserSession(app.deleteMeHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/export", app.requireUserSession(app.requestDataExportHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/erasure", app.requireUserSession(app.eraseMeHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/image", app.requirePermission("profile:write", app.uploadUserImageHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/image/update", app.requirePermission("profile:write", app.updateUserImageHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/updated/:id", app.requirePermission("profile:write", app.updateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/sessions", app.requireUserSession(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions", app.requireUserSession(app.deleteAllSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:session_id", app.requireUserSession(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa", app.requireUserSession(app.enrollMFAHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/mfa", app.requireUserSession(app.confirmMFAHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/mfa", app.requireUserSession(app.disableMFAHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa/recovery-codes", app.requireUserSession(app.regenerateRecoveryCodesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/identities", app.requireUserSession(app.listIdentitiesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/identities/:provider", app.requireUserSession(app.linkIdentityHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/identities/:provider", app.requireUserSession(app.unlinkIdentityHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/invitations", app.requireUserSession(app.listInvitationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/invitations/:id", app.requireUserSession(app.acceptInvitationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/invitations/:id", app.requireUserSession(app.declineInvitationHandler))
+
+	//API Key Routes
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", app.requireUserSession(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", app.requireUserSession(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", app.requireUserSession(app.deleteAPIKeyHandler))
 	//End User Routes
 
+	//Roles and Permissions Routes
+	router.HandlerFunc(http.MethodGet, "/v1/permissions", app.requirePermission("permissions:write", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requirePermission("permissions:write", app.listRolesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/permissions", app.requirePermission("permissions:write", app.showUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/permissions/:id", app.requirePermission("permissions:write", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/permissions/:id/:code", app.requirePermission("permissions:write", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/roles/:id", app.requirePermission("permissions:write", app.grantUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/roles/:id/:role", app.requirePermission("permissions:write", app.revokeUserRoleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/revoke-tokens/:id", app.requirePermission("users:write", app.revokeUserTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/erasure/:id", app.requirePermission("users:write", app.eraseUserHandler))
+
 	//Listing Routes
 	router.HandlerFunc(http.MethodPost, "/v1/listings", app.requirePermission("listings:write", app.createListingHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/listings/images", app.uploadListingImageHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/listings", app.showAllListingHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/listings/images", app.requirePermission("listings:write", app.uploadListingImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/listings", app.requirePermission("listings:read", app.showAllListingHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/listings/:id", app.requirePermission("listings:read", app.showListingHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/listings/update/:id", app.updateListingHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/users/listings", app.addUserListingHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/agent/listings/:id", app.getListingByAgentdHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/listings/update/:id", app.requirePermission("listings:write", app.updateListingHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/listings", app.requirePermission("listings:write", app.addUserListingHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/agent/listings/:id", app.requirePermission("listings:read", app.getListingByAgentdHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/listings/closing/:id", app.requirePermission("listings:write", app.createClosingHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/listings/:id/closing", app.requirePermission("listings:read", app.showClosingHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/listings/:id/valuation", app.requirePermission("lookups:read", app.showListingValuationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/listings/valuation", app.requirePermission("lookups:read", app.createValuationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/listings/:id/mortgage", app.requirePermission("lookups:read", app.showListingMortgageHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/mortgage/calculator", app.requirePermission("lookups:read", app.calculateMortgageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/listings/:id/closing-costs", app.requirePermission("lookups:read", app.showListingClosingCostsHandler))
 	//End of Listing Routes
 
+	//Vacation Rental Calendar Routes
+	router.HandlerFunc(http.MethodGet, "/v1/listings/:id/availability", app.requirePermission("listings:read", app.showAvailabilityHandler))
+	//left public so other booking sites can subscribe to the feed, it only shows which nights are taken
+	router.HandlerFunc(http.MethodGet, "/v1/listings/:id/calendar.ics", app.exportCalendarHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/listings/ical/:id", app.requirePermission("listings:write", app.importCalendarHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/listings/rates/:id", app.requirePermission("listings:write", app.createNightlyRateHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/listings/blocked/:id", app.requirePermission("listings:write", app.createBlockedDatesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/blocked-dates/:id", app.requirePermission("listings:write", app.deleteBlockedDatesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/listings/bookings/:id", app.requirePermission("listings:read", app.createBookingHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/bookings/:id", app.requirePermission("listings:read", app.showBookingHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/bookings/:id", app.requirePermission("listings:read", app.updateBookingHandler))
+
+	//Organization Routes
+	router.HandlerFunc(http.MethodGet, "/v1/organizations", app.requirePermission("listings:read", app.listOrganizationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/organizations", app.requirePermission("listings:write", app.createOrganizationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/organizations/:id", app.requirePermission("listings:read", app.showOrganizationHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/organizations/:id", app.requirePermission("listings:write", app.updateOrganizationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/organizations/members/:id", app.requirePermission("listings:write", app.inviteOrganizationMemberHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/organizations/:id/members/:user_id", app.requirePermission("listings:write", app.updateOrganizationMemberHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/organizations/:id/members/:user_id", app.requirePermission("listings:write", app.removeOrganizationMemberHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/organizations/:id/listings/:listing_id", app.requirePermission("listings:write", app.assignOrganizationListingHandler))
+
+	//Closing Cost Rules Routes
+	router.HandlerFunc(http.MethodGet, "/v1/closing-cost-rules", app.requirePermission("lookups:read", app.listClosingCostRulesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/closing-cost-rules/:buyer", app.requirePermission("rules:write", app.updateClosingCostRuleHandler))
+
 	//Report Routes
-	router.HandlerFunc(http.MethodGet, "/v1/report/agents", app.getTopAgentsHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/report/listings", app.getListingStatusHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/report/total-sales", app.getTotalSalesHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/report/agents", app.requirePermission("reports:read", app.getTopAgentsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/report/listings", app.requirePermission("reports:read", app.getListingStatusHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/report/total-sales", app.requirePermission("reports:read", app.getTotalSalesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/report/aging", app.requirePermission("reports:read", app.getInventoryAgingHandler))
 
 	//Currency Rate Route - Third Party API
-	router.HandlerFunc(http.MethodGet, "/v1/currencyrate/:id", app.currencyRate)
+	router.HandlerFunc(http.MethodGet, "/v1/currencyrate/:id", app.requirePermission("lookups:read", app.currencyRate))
 
 	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
 
@@ -0,0 +1,223 @@
+//Filename: cmd/api/scheduler.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/mailer"
+	"realestatebelize.imerlopez.net/internal/schedule"
+)
+
+// the reports a job can send and the title used in the email
+var reportJobTitles = map[string]string{
+	"top_agents":      "Top Agents",
+	"listing_status":  "Listing Status",
+	"total_sales":     "Total Sales",
+	"inventory_aging": "Inventory Aging",
+}
+
+// runScheduler checks the report jobs at the start of every minute until ctx is cancelled
+func (app *application) runScheduler(ctx context.Context) {
+
+	app.logger.PrintInfo("report scheduler started", nil)
+
+	for {
+		//wait for the start of the next minute
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			app.logger.PrintInfo("report scheduler stopped", nil)
+			return
+		case tick := <-timer.C:
+			app.dispatchReportJobs(tick.Truncate(time.Minute))
+		}
+	}
+}
+
+// dispatchReportJobs starts every enabled job that is due at the given minute
+func (app *application) dispatchReportJobs(now time.Time) {
+
+	jobs, err := app.models.ReportJobs.GetAllEnabled()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, job := range jobs {
+		sched, err := schedule.Parse(job.Schedule)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": job.Name})
+			continue
+		}
+
+		if !sched.Matches(now) {
+			continue
+		}
+
+		job := job
+		app.background(func() {
+			app.runReportJob(job, now)
+		})
+	}
+}
+
+// runReportJob emails the job's report to its recipients and records the run
+func (app *application) runReportJob(job *data.ReportJob, now time.Time) {
+
+	run, err := app.models.ReportJobs.StartRun(job.ID)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": job.Name})
+		return
+	}
+
+	err = app.sendReportJob(job, now)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": job.Name})
+	}
+
+	err = app.models.ReportJobs.FinishRun(run, err)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"job": job.Name})
+	}
+}
+
+func (app *application) sendReportJob(job *data.ReportJob, now time.Time) error {
+
+	title, ok := reportJobTitles[job.Report]
+	if !ok {
+		return fmt.Errorf("unknown report %q", job.Report)
+	}
+
+	//the jobs report on the week leading up to the run
+	filters := data.ReportFilters{
+		From: now.AddDate(0, 0, -7),
+		To:   now,
+	}
+
+	header, rows, err := app.reportTable(job.Report, filters)
+	if err != nil {
+		return err
+	}
+
+	//the csv attachment and the html table are built from the same rows
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(header)
+
+	cells := make([][]string, len(rows))
+	for i, row := range rows {
+		cells[i] = make([]string, len(row))
+		for j := range row {
+			cells[i][j] = formatCell(row[j])
+		}
+		cw.Write(cells[i])
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	attachment := mailer.Attachment{
+		Filename: fmt.Sprintf("%s-%s.csv", job.Report, now.Format(dateLayout)),
+		Content:  buf.Bytes(),
+	}
+
+	data := map[string]interface{}{
+		"jobName": job.Name,
+		"title":   title,
+		"from":    filters.From.Format(dateLayout),
+		"to":      filters.To.Format(dateLayout),
+		"header":  header,
+		"rows":    cells,
+	}
+
+	//users can turn report digests off in their notification preferences
+	optOuts, err := app.models.Notifications.ReportDigestOptOuts(job.Recipients)
+	if err != nil {
+		return err
+	}
+
+	//one recipient that cannot be reached does not stop the others getting the digest
+	var failed []string
+
+	for _, recipient := range job.Recipients {
+		if optOuts[recipient] {
+			continue
+		}
+
+		err := app.mailer.Send(recipient, "report_digest.tmpl", data, attachment)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": job.Name, "recipient": recipient})
+			failed = append(failed, recipient)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("sending to %s failed", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// reportTable runs one of the reports and returns it as a header and rows
+func (app *application) reportTable(report string, filters data.ReportFilters) ([]string, [][]interface{}, error) {
+
+	var rows [][]interface{}
+
+	switch report {
+	case "top_agents":
+		agents, err := app.models.TopAgents.GetTopAgents(data.TopAgentsFilters{ReportFilters: filters, RankBy: "volume", Limit: 10})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, agent := range agents {
+			rows = append(rows, topAgentsRow(agent))
+		}
+		return topAgentsHeader, rows, nil
+
+	case "listing_status":
+		//the status report covers all listings, not only the ones created this week
+		statuses, err := app.models.ListingsStatus.GetListingStatus(data.ReportFilters{GroupBy: "district"})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, status := range statuses {
+			rows = append(rows, listingStatusRow(status))
+		}
+		return listingStatusHeader, rows, nil
+
+	case "total_sales":
+		filters.GroupBy = "district"
+		sales, err := app.models.TotalSales.GetTotalSales(filters)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, sale := range sales {
+			rows = append(rows, totalSalesRow(sale))
+		}
+		return totalSalesHeader, rows, nil
+
+	case "inventory_aging":
+		aging, err := app.models.InventoryAging.GetInventoryAging(data.ReportFilters{})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range aging {
+			rows = append(rows, inventoryAgingRow(a))
+		}
+		return inventoryAgingHeader, rows, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown report %q", report)
+}
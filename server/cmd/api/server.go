@@ -30,6 +30,42 @@ func (app *application) serve() error {
 
 	shutdownError := make(chan error)
 
+	//background loops such as the report scheduler stop when the server shuts down
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	if app.config.scheduler.enabled {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			app.runScheduler(backgroundCtx)
+		}()
+	}
+
+	//save token and API key last used times in batches, the final batch is written on shutdown
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.runSessionFlusher(backgroundCtx)
+	}()
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.runAPIKeyFlusher(backgroundCtx)
+	}()
+
+	//signed tokens are checked against an in memory denylist kept fresh from the database
+	if app.signer != nil {
+		app.refreshDenylist()
+
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			app.runDenylistRefresher(backgroundCtx)
+		}()
+	}
+
 	//start a background go routine
 
 	go func() {
@@ -50,8 +86,16 @@ func (app *application) serve() error {
 		//create a context  with a 20 sec timeout
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
-		//call the shutdown function
-		shutdownError <- srv.Shutdown(ctx)
+		//call the shutdown function, the background tasks are finished even when it fails
+		err := srv.Shutdown(ctx)
+
+		//stop the scheduler and session flusher and wait for background tasks such as emails to complete
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+		stopBackground()
+		app.wg.Wait()
+		shutdownError <- err
 
 	}()
 
@@ -0,0 +1,224 @@
+//Filename: cmd/api/sessions.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+)
+
+// how often the last used times of tokens are written to the database
+const sessionFlushInterval = time.Minute
+
+// lastUsed collects when each token was last seen so authenticate does not
+// need a database write on every request
+type lastUsed struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// touch records that the token stored under hash was used now
+func (l *lastUsed) touch(hash []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen == nil {
+		l.seen = make(map[string]time.Time)
+	}
+	l.seen[string(hash)] = time.Now()
+}
+
+// get returns the unsaved last used time of a token
+func (l *lastUsed) get(hash []byte) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at, ok := l.seen[string(hash)]
+	return at, ok
+}
+
+// drain hands back everything collected so far and starts over
+func (l *lastUsed) drain() map[string]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seen := l.seen
+	l.seen = nil
+	return seen
+}
+
+// runSessionFlusher saves the collected last used times every interval and
+// once more when ctx is cancelled
+func (app *application) runSessionFlusher(ctx context.Context) {
+
+	ticker := time.NewTicker(sessionFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			app.flushSessions()
+			return
+		case <-ticker.C:
+			app.flushSessions()
+		}
+	}
+}
+
+func (app *application) flushSessions() {
+
+	err := app.models.Tokens.TouchLastUsed(app.sessions.drain())
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// clientIP returns the address of the client without the port
+func clientIP(r *http.Request) string {
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// log out by revoking the token of the request along with its refresh token
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	//a signed token cannot be deleted, it is denied until it expires
+	if claims := app.contextGetClaims(r); claims != nil {
+		err := app.denyToken(claims)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.models.Tokens.DeleteFamily(claimsFamily(claims))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		err := app.models.Tokens.DeleteFamilyByHash(app.contextGetToken(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// list the devices the user is signed in on. Only /v1/users/me/sessions is served,
+// the router needs the :id segment to share the tree with /v1/users/:id
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if app.readStringParam(r, "id") != "me" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetSessions(app.contextGetUser(r).ID, app.contextGetToken(r), claimsFamily(app.contextGetClaims(r)))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//the current session was just used, that may not be saved yet
+	if at, ok := app.sessions.get(app.contextGetToken(r)); ok {
+		for _, session := range sessions {
+			if session.Current && (session.LastUsedAt == nil || at.After(*session.LastUsedAt)) {
+				at := at.Truncate(time.Second)
+				session.LastUsedAt = &at
+			}
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revoke one of the user's sessions
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readNamedIdParam(r, "session_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteSession(app.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// log out everywhere, including the session of the request
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	err := app.revokeAllSessions(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeAllSessions signs a user out of every device, signed tokens they hold are denied
+func (app *application) revokeAllSessions(userID int64) error {
+
+	err := app.models.Tokens.DeleteSessionsForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	return app.denyUser(userID)
+}
+
+// emergency sign out of a user by an admin, for example when an account is compromised
+func (app *application) revokeUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, ok := app.readGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.revokeAllSessions(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions of the user revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
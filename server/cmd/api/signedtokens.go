@@ -0,0 +1,222 @@
+//Filename: cmd/api/signedtokens.go
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/signedtoken"
+)
+
+// how often the denylist is reloaded so revocations made by other servers are picked up
+const denylistRefreshInterval = 30 * time.Second
+
+// denylist is the in memory copy of the token_denylist table, authenticate checks
+// signed tokens against it without going to the database
+type denylist struct {
+	mu     sync.RWMutex
+	tokens map[string]bool
+	users  map[int64]time.Time
+}
+
+// replace swaps in the entries loaded from the database
+func (d *denylist) replace(entries []*data.DenylistEntry) {
+
+	tokens := make(map[string]bool)
+	users := make(map[int64]time.Time)
+
+	for _, entry := range entries {
+		if entry.JTI != "" {
+			tokens[entry.JTI] = true
+		}
+		if entry.UserID != 0 && entry.CreatedAt.After(users[entry.UserID]) {
+			users[entry.UserID] = entry.CreatedAt
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tokens = tokens
+	d.users = users
+}
+
+func (d *denylist) denyToken(jti string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.tokens == nil {
+		d.tokens = make(map[string]bool)
+	}
+	d.tokens[jti] = true
+}
+
+func (d *denylist) denyUser(userID int64, since time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.users == nil {
+		d.users = make(map[int64]time.Time)
+	}
+	if since.After(d.users[userID]) {
+		d.users[userID] = since
+	}
+}
+
+// denied reports whether the token was revoked by id or issued before its user was revoked
+func (d *denylist) denied(claims *signedtoken.Claims) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.tokens[claims.ID] {
+		return true
+	}
+
+	since, ok := d.users[claims.UserID]
+
+	return ok && claims.Issued().Before(since)
+}
+
+// runDenylistRefresher reloads the denylist every interval until ctx is cancelled
+func (app *application) runDenylistRefresher(ctx context.Context) {
+
+	ticker := time.NewTicker(denylistRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.refreshDenylist()
+		}
+	}
+}
+
+func (app *application) refreshDenylist() {
+
+	entries, err := app.models.Denylist.GetActive()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	app.denylist.replace(entries)
+}
+
+// denyUser revokes the signed tokens a user already holds, it does nothing when
+// access tokens are kept in the database since those are simply deleted
+func (app *application) denyUser(userID int64) error {
+
+	if app.signer == nil {
+		return nil
+	}
+
+	since, err := app.models.Denylist.DenyUser(userID, time.Now().Add(app.config.tokens.accessTTL))
+	if err != nil {
+		return err
+	}
+
+	app.denylist.denyUser(userID, since)
+	return nil
+}
+
+// denyToken revokes a single signed token
+func (app *application) denyToken(claims *signedtoken.Claims) error {
+
+	err := app.models.Denylist.DenyToken(claims.ID, time.Unix(claims.Expiry, 0))
+	if err != nil {
+		return err
+	}
+
+	app.denylist.denyToken(claims.ID)
+	return nil
+}
+
+// signAccessToken fills in the access token of a pair when access tokens are signed,
+// the permissions, activation and two-factor status of the user are copied into it
+func (app *application) signAccessToken(pair *data.TokenPair, user *data.User) error {
+
+	if app.signer == nil {
+		return nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	mfaEnabled, err := app.models.MFA.IsEnabled(user.ID)
+	if err != nil {
+		return err
+	}
+
+	claims := &signedtoken.Claims{
+		UserID:      user.ID,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		MFA:         mfaEnabled,
+		Family:      hex.EncodeToString(pair.Refresh.Family),
+	}
+
+	plaintext, err := app.signer.Sign(claims, app.config.tokens.accessTTL)
+	if err != nil {
+		return err
+	}
+
+	pair.Access = &data.Token{
+		Plaintext: plaintext,
+		UserID:    user.ID,
+		Expiry:    time.Unix(claims.Expiry, 0),
+		Scope:     data.ScopeAuthentication,
+	}
+
+	return nil
+}
+
+// userPermissions returns the permissions of the signed in user, from the token
+// when it is signed and from the database otherwise
+func (app *application) userPermissions(r *http.Request) (data.Permissions, error) {
+
+	if claims := app.contextGetClaims(r); claims != nil {
+		return data.Permissions(claims.Permissions), nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return nil, err
+	}
+
+	//an API key can do no more than its scopes and no more than its user
+	if key := app.contextGetAPIKey(r); key != nil {
+		scoped := data.Permissions{}
+		for _, scope := range key.Scopes {
+			if permissions.Includes(scope) {
+				scoped = append(scoped, scope)
+			}
+		}
+		return scoped, nil
+	}
+
+	return permissions, nil
+}
+
+// claimsFamily returns the refresh token family of a signed token
+func claimsFamily(claims *signedtoken.Claims) []byte {
+
+	if claims == nil {
+		return nil
+	}
+
+	family, err := hex.DecodeString(claims.Family)
+	if err != nil {
+		return nil
+	}
+
+	return family
+}
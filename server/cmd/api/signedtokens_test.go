@@ -0,0 +1,42 @@
+//Filename: cmd/api/signedtokens_test.go
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/signedtoken"
+)
+
+func TestDenylistUser(t *testing.T) {
+
+	revoked := time.Date(2026, 10, 19, 12, 0, 0, 500000000, time.UTC)
+
+	var d denylist
+	d.replace([]*data.DenylistEntry{{UserID: 7, CreatedAt: revoked}})
+
+	tests := []struct {
+		name   string
+		claims signedtoken.Claims
+		denied bool
+	}{
+		{"signed earlier in the same second", signedtoken.Claims{UserID: 7, IssuedAt: revoked.Unix(), IssuedAtMicro: revoked.Add(-time.Millisecond).UnixMicro()}, true},
+		{"refreshed later in the same second", signedtoken.Claims{UserID: 7, IssuedAt: revoked.Unix(), IssuedAtMicro: revoked.Add(time.Millisecond).UnixMicro()}, false},
+		{"signed without iat_us in that second", signedtoken.Claims{UserID: 7, IssuedAt: revoked.Unix()}, true},
+		{"signed the next second", signedtoken.Claims{UserID: 7, IssuedAt: revoked.Unix() + 1}, false},
+		{"another user", signedtoken.Claims{UserID: 8, IssuedAt: revoked.Unix() - 60}, false},
+	}
+
+	for _, tt := range tests {
+		if got := d.denied(&tt.claims); got != tt.denied {
+			t.Errorf("%s: got denied=%v, want %v", tt.name, got, tt.denied)
+		}
+	}
+
+	d.denyToken("abc")
+	if !d.denied(&signedtoken.Claims{ID: "abc", UserID: 8, IssuedAt: revoked.Unix() + 60}) {
+		t.Error("a token denied by id was let through")
+	}
+}
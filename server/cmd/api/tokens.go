@@ -35,44 +35,319 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	//slow down or refuse usernames and addresses with recent failed logins
+	userKey, ipKey := loginKeys(input.Username, r)
+
+	throttle, err := app.beginLoginAttempt(userKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if throttle != nil {
+		app.loginThrottledResponse(w, r, throttle)
+		return
+	}
+
 	//get the user detials on the provided  username
 	user, err := app.models.Users.GetByUsername(input.Username)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//check if the password mathces, unknown usernames take just as long
+	match := false
+	if user != nil {
+		match, err = user.Password.Matches(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		data.DummyPasswordCheck(input.Password)
+	}
+
+	//if password don't match then return an invalid credentials response
+	if !match {
+		err = app.recordLoginFailure(r, userKey, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.endLoginAttempt(ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//the failures of the username are only forgotten once the session starts, after the second factor
+	app.beginSession(w, r, user)
+}
+
+// beginSession signs in a user who has proved who they are. Users with two-factor
+// authentication get a short lived mfa token to swap, along with a code, for their
+// tokens at /v1/tokens/mfa
+func (app *application) beginSession(w http.ResponseWriter, r *http.Request, user *data.User) {
+
+	mfaEnabled, err := app.models.MFA.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if mfaEnabled {
+		token, err := app.models.Tokens.New(user.ID, mfaTokenTTL, data.ScopeMFA)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_required": true, "mfa_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeNewSession(w, r, user)
+}
+
+// writeNewSession signs the user in on the device of the request and returns the
+// short lived authentication token and the refresh token to client
+func (app *application) writeNewSession(w http.ResponseWriter, r *http.Request, user *data.User) {
+
+	//every factor has passed, forget the failed logins of the username
+	userKey, _ := loginKeys(user.Username, r)
+
+	err := app.models.LoginFailures.Reset(userKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	pair, err := app.models.Tokens.NewPair(user.ID, app.tokenTTL(), r.UserAgent(), clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.signAccessToken(pair, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": pair.Access, "refresh_token": pair.Refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// tokenTTL returns how long the access and refresh tokens of a sign in live
+func (app *application) tokenTTL() data.TokenTTL {
+	return data.TokenTTL{
+		Access:          app.config.tokens.accessTTL,
+		Refresh:         app.config.tokens.refreshTTL,
+		StatelessAccess: app.signer != nil,
+	}
+}
+
+// swap a refresh token for a new authentication and refresh token. Each refresh token
+// works once, replaying an old one signs that device out
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pair, err := app.models.Tokens.Rotate(input.RefreshToken, app.tokenTTL(), r.UserAgent(), clientIP(r))
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+		case errors.Is(err, data.ErrInvalidRefreshToken):
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.logger.PrintInfo("refresh token reused, session revoked", map[string]string{"ip": clientIP(r)})
+			app.invalidRefreshTokenResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
-
 		return
 	}
 
-	//check if the password mathces
+	//signed tokens pick up changes to the user's permissions on refresh
+	if app.signer != nil {
+		user, err := app.models.Users.GetByID(pair.Refresh.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.signAccessToken(pair, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
-	match, err := user.Password.Matches(input.Password)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": pair.Access, "refresh_token": pair.Refresh}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// email a password reset token, the response is the same whether or not the
+// email belongs to an account so it cannot be used to look up users
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	//if password don't match then return an invalid credentials response
-	if !match {
-		app.invalidCredentialsResponse(w, r)
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	//Password is correct, generate authentication token
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	env := envelope{"message": "if an account with that email exists, you will receive password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	//return the authentication token to client
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	app.background(func() {
+
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
+
+// an account is sent at most this many activation emails a day, and one every
+// activationResendGap
+const (
+	maxActivationEmails = 5
+	activationResendGap = 2 * time.Minute
+)
+
+// send a new activation token to a user whose welcome email got lost. The answer is
+// the same whether or not the email is sent so it does not tell who has an account
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if an account with that email needs activating, you will receive activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	send := user != nil && !user.Activated
+
+	if send {
+		count, last, err := app.models.Tokens.CountRecent(user.ID, data.ScopeActivation, 24*time.Hour)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if count >= maxActivationEmails || (last != nil && time.Since(*last) < activationResendGap) {
+			app.logger.PrintInfo("activation email throttled", map[string]string{"user_id": fmt.Sprint(user.ID)})
+			send = false
+		}
+	}
 
+	if send {
+		token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+
+			data := map[string]interface{}{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -41,11 +41,23 @@ func (app *application) addUserListingHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	//only the owning agent, their broker or an admin may add agents to the listing
+	if !app.allowListingEdit(w, r, userlisting.ListingId) {
+		return
+	}
+
 	//add listing to agent
 
 	err = app.models.UserListings.Insert(userlisting)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("username", "no user with this username")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
 	//write json response with 201
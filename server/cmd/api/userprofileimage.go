@@ -3,8 +3,6 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 
 	"realestatebelize.imerlopez.net/internal/data"
@@ -18,14 +16,8 @@ func (app *application) uploadUserImageHandler(w http.ResponseWriter, r *http.Re
 	// 	ImageUrl string `json:"image_url"`
 	// }
 
-	//get the id for last user account created
-
-	user, err := app.models.UserProfileImage.GetByUserId()
-
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
+	//the image belongs to the signed in user
+	user := app.contextGetUser(r).ID
 
 	imagePath, err := app.uploadFiles(r)
 
@@ -66,28 +58,8 @@ func (app *application) uploadUserImageHandler(w http.ResponseWriter, r *http.Re
 
 func (app *application) updateUserImageHandler(w http.ResponseWriter, r *http.Request) {
 
-	var input struct {
-		Username   string `json:"username"`
-		ProfileImg string `json:"profile_image_url"`
-	}
-
-	//initialize the new json.decoder instance
-
-	err := json.NewDecoder(r.Body).Decode(&input)
-	if err != nil {
-		fmt.Println("dffdfd")
-		app.badRequestResponse(w, r, err)
-		return
-	}
-
-	// // //get user Id by username
-	user, err := app.models.UserProfileImage.GetIdByUsername(input.Username)
-
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-	fmt.Println(user)
+	//users may only replace their own profile image
+	user := app.contextGetUser(r).ID
 
 	imagePath, err := app.uploadFiles(r)
 
@@ -69,6 +69,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateUsername):
+			v.AddError("username", "a user with this username already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -76,8 +79,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	//Add permissions for the newly inserted users
-	err = app.models.Permissions.AddForUser(user.ID, "listings:read")
+	//new users are buyers, admins grant the other roles
+	err = app.models.Roles.AddForUser(user.ID, data.RoleBuyer)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -139,6 +142,18 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	//users may only edit themselves unless they are an admin
+	admin, err := app.isAdmin(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.ID != app.contextGetUser(r).ID && !admin {
+		app.notPerrmittedResponse(w, r)
+		return
+	}
+
 	//Create an input Struct to hold data read in from client
 
 	var input struct {
@@ -149,7 +164,7 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		Address    *string `json:"address"`
 		DistrictId *string `json:"district_id"`
 		UserTypeId *string `json:"user_type_id"`
-		Activated  bool    `json:"activated"`
+		Activated  *bool   `json:"activated"`
 	}
 	//intialize new json.decoder instance
 
@@ -170,9 +185,10 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		user.Fullname = *input.Fullname
 	}
 
-	if input.Email != nil {
-
-		user.Email = *input.Email
+	//a new email address is only used once it is confirmed from that inbox
+	newEmail := ""
+	if input.Email != nil && *input.Email != user.Email {
+		newEmail = *input.Email
 	}
 
 	if input.Phone != nil {
@@ -193,23 +209,41 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		user.UserTypeId = *input.UserTypeId
 	}
 
+	//only an admin may activate or deactivate an account
+	if input.Activated != nil {
+		if !admin {
+			app.notPerrmittedResponse(w, r)
+			return
+		}
+		user.Activated = *input.Activated
+	}
+
 	//Initalize a new Validator
 	v := validator.New()
 
 	//check the map to determine if there were any validation errors
 
-	if data.ValidateUserListing(v, user); !v.Valid() {
+	data.ValidateUserListing(v, user)
+	if newEmail != "" {
+		data.ValidateEmail(v, newEmail)
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
-
 	}
 
+	//the profile is saved first so a username taken meanwhile does not leave a
+	//confirmation email behind
 	err = app.models.Users.UpdateUser(user)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateUsername):
+			v.AddError("username", "a user with this username already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -217,59 +251,109 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	//wreite data return by the update
-	err = app.writeJSON(w, http.StatusOK, envelope{"users": user}, nil)
+	if newEmail != "" {
+		err = app.requestEmailChange(user.ID, newEmail)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateEmail):
+				v.AddError("email", "a user with this email address already exists")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	env := envelope{"users": user}
+	if newEmail != "" {
+		env["message"] = emailChangeMessage
+	}
 
+	//wreite data return by the update
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
-// reset password
-func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	//Hold data from the request body
+// set a new password using the token from the password reset email
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Password       string `json:"password"`
+		TokenPlainText string `json:"token"`
 	}
 
-	//Parse the request body into the anonymous struct
 	err := app.readJSON(w, r, &input)
-
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	user := &data.UserResetPassword{
-		Username: input.Username,
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlainText(v, input.TokenPlainText)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	//Generate a password Hash
-	err = user.Password.Set(input.Password)
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 
+	err = user.Password.Set(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	//Perform Validation
-	v := validator.New()
-
-	if data.ValidateUserReset(v, user); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	//insert data to database
+	//the reset token is single use and anyone holding an old login
+	//token is signed out
+	err = app.models.Tokens.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	err = app.models.Users.ResetPassword(user)
+	err = app.denyUser(user.ID)
 	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
+	//a new password also lifts a lockout
+	userKey, _ := loginKeys(user.Username, r)
+
+	err = app.models.LoginFailures.Reset(userKey)
+	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	//Write a 202 Accepted Status
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -348,6 +432,114 @@ func (app *application) activatedUserHandler(w http.ResponseWriter, r *http.Requ
 
 }
 
+const emailChangeMessage = "a confirmation email has been sent to the new address, the email will change once it is confirmed"
+
+// requestEmailChange keeps the new address as pending and emails it a token to confirm
+// it with. Only the newest request can be confirmed
+func (app *application) requestEmailChange(userID int64, newEmail string) error {
+
+	err := app.models.Users.SetPendingEmail(userID, newEmail)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Tokens.DeleteAllForUsers(data.ScopeEmailChange, userID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(userID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+
+		data := map[string]interface{}{
+			"emailChangeToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(newEmail, "token_email_change.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	return nil
+}
+
+// confirm a new email address with the token sent to it. The old address is told
+// about the change
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlainText(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldEmail, err := app.models.Users.ConfirmPendingEmail(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUsers(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+
+		data := map[string]interface{}{
+			"newEmail": user.Email,
+		}
+
+		err := app.mailer.Send(oldEmail, "email_changed.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // Show user for get by id
 
 func (app *application) getUserByIdHandler(w http.ResponseWriter, r *http.Request) {
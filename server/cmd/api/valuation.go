@@ -0,0 +1,101 @@
+//Filename: cmd/api/valuation.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"realestatebelize.imerlopez.net/internal/data"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// estimate the value of a listing from comparable sales
+func (app *application) showListingValuationHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	subject, err := app.models.Valuation.GetSubject(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//the listing needs an area before it can be compared
+	v := validator.New()
+
+	data.ValidateSaleListing(v, subject.ListingType)
+
+	if data.ValidateValuationSubject(v, subject); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.writeValuation(w, r, subject)
+}
+
+// estimate the value of a property that is not listed
+func (app *application) createValuationHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		DistrictId     int64   `json:"district_id"`
+		PropertyTypeId int64   `json:"property_type_id"`
+		Area           float64 `json:"area"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	subject := &data.ValuationSubject{
+		DistrictId:     input.DistrictId,
+		PropertyTypeId: input.PropertyTypeId,
+		Area:           input.Area,
+	}
+
+	v := validator.New()
+
+	if data.ValidateValuationSubject(v, subject); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.writeValuation(w, r, subject)
+}
+
+// writeValuation finds the comparables for the subject and writes the estimate
+func (app *application) writeValuation(w http.ResponseWriter, r *http.Request, subject *data.ValuationSubject) {
+
+	comps, err := app.models.Valuation.FindComparables(*subject)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	valuation, err := data.EstimateValue(*subject, comps)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoComparables):
+			app.noComparablesResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"valuation": valuation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,325 @@
+// Filename: internal/data/apikeys.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// an API key is written rbz_<prefix>_<secret>, the prefix finds the key and is safe to show
+const apiKeyTag = "rbz"
+
+// the most requests a minute a key may be given
+const maxAPIKeyRateLimit = 6000
+
+// APIKey is a credential for scripts and partner portals
+type APIKey struct {
+	ID             int64      `json:"id"`
+	Plaintext      string     `json:"key,omitempty"`
+	Prefix         string     `json:"prefix"`
+	Hash           []byte     `json:"-"`
+	Name           string     `json:"name"`
+	UserID         int64      `json:"user_id"`
+	OrganizationID *int64     `json:"organization_id"`
+	Scopes         []string   `json:"scopes"`
+	AllowedIPs     []string   `json:"allowed_ips"`
+	RateLimit      int        `json:"rate_limit"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UserActivated  bool       `json:"-"`
+}
+
+// AllowsIP reports whether the key may be used from ip, an empty allowlist allows any address
+func (k *APIKey) AllowsIP(ip string) bool {
+
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, allowed := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(allowed); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired reports whether the key has passed its expiry
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// generateAPIKey fills in the plaintext, prefix and hash of a new key
+func generateAPIKey(key *APIKey) error {
+
+	random := make([]byte, 25)
+	_, err := rand.Read(random)
+	if err != nil {
+		return err
+	}
+
+	encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(random))
+
+	key.Prefix = encoded[:8]
+	key.Plaintext = apiKeyTag + "_" + key.Prefix + "_" + encoded[8:]
+	key.Hash = apiKeyHash(key.Plaintext)
+
+	return nil
+}
+
+func apiKeyHash(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// APIKeyPrefix returns the prefix of a key as sent by a client
+func APIKeyPrefix(plaintext string) (string, bool) {
+
+	parts := strings.Split(plaintext, "_")
+	if len(parts) != 3 || parts[0] != apiKeyTag || len(parts[1]) != 8 || parts[2] == "" {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+// ValidateAPIKey checks a new key, granted are the permissions of the user making it
+func ValidateAPIKey(v *validator.Validator, key *APIKey, granted Permissions) {
+
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least one permission")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+	for _, scope := range key.Scopes {
+		v.Check(granted.Includes(scope), "scopes", "must only contain permissions you have: "+scope)
+	}
+
+	v.Check(len(key.AllowedIPs) <= 20, "allowed_ips", "must not contain more than 20 entries")
+	for _, allowed := range key.AllowedIPs {
+		_, _, err := net.ParseCIDR(allowed)
+		v.Check(err == nil || net.ParseIP(allowed) != nil, "allowed_ips", "must contain IP addresses or CIDR ranges: "+allowed)
+	}
+
+	v.Check(key.RateLimit > 0, "rate_limit", "must be greater than zero")
+	v.Check(key.RateLimit <= maxAPIKeyRateLimit, "rate_limit", "must not be more than 6000 requests a minute")
+
+	if key.ExpiresAt != nil {
+		v.Check(key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert() stores a new key, its plaintext is only available on the returned key
+func (m APIKeyModel) Insert(key *APIKey) error {
+
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO api_keys(prefix, hash, name, user_id, organization_id, scopes, allowed_ips, rate_limit, expires_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`
+
+	args := []interface{}{
+		key.Prefix,
+		key.Hash,
+		key.Name,
+		key.UserID,
+		key.OrganizationID,
+		pq.Array(key.Scopes),
+		pq.Array(key.AllowedIPs),
+		key.RateLimit,
+		key.ExpiresAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+const apiKeyColumns = `k.id, k.prefix, k.hash, k.name, k.user_id, k.organization_id, k.scopes, k.allowed_ips,
+	k.rate_limit, k.expires_at, k.last_used_at, k.created_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }, key *APIKey, extra ...interface{}) error {
+	return row.Scan(append([]interface{}{
+		&key.ID,
+		&key.Prefix,
+		&key.Hash,
+		&key.Name,
+		&key.UserID,
+		&key.OrganizationID,
+		pq.Array(&key.Scopes),
+		pq.Array(&key.AllowedIPs),
+		&key.RateLimit,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+	}, extra...)...)
+}
+
+// GetForPlaintext() finds the key a client sent, checking the secret in constant time
+func (m APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, error) {
+
+	prefix, ok := APIKeyPrefix(plaintext)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + apiKeyColumns + `, u.activated
+		FROM api_keys k
+		INNER JOIN users u ON u.id = k.user_id
+		WHERE k.prefix = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	err := scanAPIKey(m.DB.QueryRowContext(ctx, query, prefix), &key, &key.UserActivated)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if subtle.ConstantTimeCompare(key.Hash, apiKeyHash(plaintext)) != 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return &key, nil
+}
+
+// GetAllForUser() returns the keys the user made and the keys of the agencies they manage
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys k
+		WHERE k.user_id = $1 OR k.organization_id IN (
+			select organization_id from organization_members
+			where user_id = $1 and role IN ('broker', 'admin')
+		)
+		ORDER BY k.id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+
+		err := scanAPIKey(rows, &key)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete() revokes a key the user made or that belongs to an agency they manage
+func (m APIKeyModel) Delete(id, userID int64) error {
+
+	query := `
+		DELETE FROM api_keys k
+		WHERE k.id = $1 AND (k.user_id = $2 OR k.organization_id IN (
+			select organization_id from organization_members
+			where user_id = $2 and role IN ('broker', 'admin')
+		))
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TouchLastUsed() saves a batch of last used times keyed by key hash
+func (m APIKeyModel) TouchLastUsed(used map[string]time.Time) error {
+
+	if len(used) == 0 {
+		return nil
+	}
+
+	hashes := make([][]byte, 0, len(used))
+	times := make([]string, 0, len(used))
+	for hash, at := range used {
+		hashes = append(hashes, []byte(hash))
+		times = append(times, at.UTC().Format(time.RFC3339))
+	}
+
+	query := `
+		UPDATE api_keys k SET last_used_at = u.used
+		FROM unnest($1::bytea[], $2::timestamptz[]) AS u(hash, used)
+		WHERE k.hash = u.hash AND (k.last_used_at IS NULL OR k.last_used_at < u.used)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(hashes), pq.Array(times))
+	return err
+}
@@ -0,0 +1,98 @@
+// Filename: internal/data/audit.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// the actions written to the audit log
+const (
+	AuditAccountLocked   = "account_locked"
+	AuditAccountUnlocked = "account_unlocked"
+	AuditAccountClosed   = "account_closed"
+	AuditAccountErased   = "account_erased"
+)
+
+// AuditEntry is a security relevant event, UserID is 0 when no user is known
+type AuditEntry struct {
+	ID        int64             `json:"id"`
+	UserID    int64             `json:"user_id"`
+	Action    string            `json:"action"`
+	IP        string            `json:"ip"`
+	Details   map[string]string `json:"details"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Insert() writes an entry to the audit log
+func (m AuditModel) Insert(entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log(user_id, action, ip, details)
+		VALUES(NULLIF($1, 0), $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, entry.UserID, entry.Action, entry.IP, details).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAllForUser() returns the user's audit log entries, newest first
+func (m AuditModel) GetAllForUser(userID int64) ([]*AuditEntry, error) {
+	query := `
+		SELECT id, user_id, action, ip, details, created_at
+		FROM audit_log
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var details []byte
+
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.Action, &entry.IP, &details, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(details, &entry.Details)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,314 @@
+//Filename: internal/data/bookings.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// the status of a booking, only pending and confirmed bookings hold their nights
+const (
+	BookingPending   = "pending"
+	BookingConfirmed = "confirmed"
+	BookingDeclined  = "declined"
+	BookingCancelled = "cancelled"
+)
+
+// the statuses a booking can be moved to from its current status
+var bookingTransitions = map[string][]string{
+	BookingPending:   {BookingConfirmed, BookingDeclined, BookingCancelled},
+	BookingConfirmed: {BookingCancelled},
+}
+
+// Booking is a guest's request to stay from CheckIn up to the morning of CheckOut
+type Booking struct {
+	ID         int64     `json:"id"`
+	ListingID  int64     `json:"listing_id"`
+	GuestID    int64     `json:"guest_id"`
+	CheckIn    time.Time `json:"check_in"`
+	CheckOut   time.Time `json:"check_out"`
+	Nights     int       `json:"nights"`
+	Guests     int32     `json:"guests"`
+	TotalPrice float64   `json:"total_price"`
+	Status     string    `json:"status"`
+	Message    string    `json:"message,omitempty"`
+	Version    int32     `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CanMoveTo reports if the booking may be moved to the status
+func (b *Booking) CanMoveTo(status string) bool {
+	return validator.In(status, bookingTransitions[b.Status]...)
+}
+
+func ValidateBooking(v *validator.Validator, booking *Booking) {
+	ValidateDateRange(v, "check_in", "check_out", booking.CheckIn, booking.CheckOut)
+
+	if !booking.CheckIn.IsZero() {
+		v.Check(!booking.CheckIn.Before(time.Now().UTC().Truncate(24*time.Hour)), "check_in", "must not be in the past")
+	}
+
+	v.Check(booking.Guests > 0, "guests", "must be at least one")
+	v.Check(booking.Guests <= 50, "guests", "must not be more than 50")
+	v.Check(len(booking.Message) <= 1000, "message", "must not be more than 1000 bytes long")
+}
+
+// Define a BookingModel which wrap a sql.DB connection pool
+type BookingModel struct {
+	DB *sql.DB
+}
+
+// Insert() prices and saves a booking request. The listing row is locked for the
+// length of the transaction so two requests for the same nights are handled one
+// after the other, the exclusion constraint on the table is the last line of defence
+func (m BookingModel) Insert(booking *Booking) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	listing, err := getCalendarListing(ctx, tx, booking.ListingID, true)
+	if err != nil {
+		return err
+	}
+
+	if !listing.IsVacationRental() {
+		return ErrNotVacationRental
+	}
+
+	if listing.AvailableFrom != nil && booking.CheckIn.Before(*listing.AvailableFrom) {
+		return ErrDatesUnavailable
+	}
+
+	blocks, err := getBlocks(ctx, tx, booking.ListingID, booking.CheckIn, booking.CheckOut)
+	if err != nil {
+		return err
+	}
+
+	bookings, err := getLiveBookings(ctx, tx, booking.ListingID, booking.CheckIn, booking.CheckOut)
+	if err != nil {
+		return err
+	}
+
+	if len(blocks) > 0 || len(bookings) > 0 {
+		return ErrDatesUnavailable
+	}
+
+	rates, err := getRates(ctx, tx, booking.ListingID, booking.CheckIn, booking.CheckOut)
+	if err != nil {
+		return err
+	}
+
+	booking.Nights = nights(booking.CheckIn, booking.CheckOut)
+	booking.TotalPrice = QuoteStay(listing, rates, booking.CheckIn, booking.CheckOut)
+	booking.Status = BookingPending
+
+	query := `
+		INSERT INTO bookings(listing_id, guest_id, check_in, check_out, guests, total_price, status, message)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, version, created_at
+	`
+
+	args := []interface{}{
+		booking.ListingID,
+		booking.GuestID,
+		booking.CheckIn,
+		booking.CheckOut,
+		booking.Guests,
+		booking.TotalPrice,
+		booking.Status,
+		booking.Message,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&booking.ID, &booking.Version, &booking.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: conflicting key value violates exclusion constraint "bookings_no_overlap"`:
+			return ErrDatesUnavailable
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get() returns a booking by id
+func (m BookingModel) Get(id int64) (*Booking, error) {
+
+	//Ensure that there is a valid id
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, listing_id, guest_id, check_in, check_out, guests, total_price, status, message, version, created_at
+		FROM bookings
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	booking, err := scanBooking(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return booking, nil
+}
+
+// UpdateStatus() saves a new status, the version check stops a guest cancelling
+// while the owner confirms
+func (m BookingModel) UpdateStatus(booking *Booking) error {
+
+	query := `
+		UPDATE bookings
+		SET status = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, booking.Status, booking.ID, booking.Version).Scan(&booking.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetUpcoming() returns the pending and confirmed bookings that have not ended, for ical export
+func (m BookingModel) GetUpcoming(listingID int64) ([]*Booking, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	return getLiveBookings(ctx, m.DB, listingID, today, today.AddDate(2, 0, 0))
+}
+
+// GetAllForGuest() returns every booking the user made, newest first
+func (m BookingModel) GetAllForGuest(guestID int64) ([]*Booking, error) {
+
+	query := `
+		SELECT id, listing_id, guest_id, check_in, check_out, guests, total_price, status, message, version, created_at
+		FROM bookings
+		WHERE guest_id = $1
+		ORDER BY check_in DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, guestID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	bookings := []*Booking{}
+
+	for rows.Next() {
+		booking, err := scanBooking(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		bookings = append(bookings, booking)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bookings, nil
+}
+
+// getLiveBookings returns the pending and confirmed bookings covering any night of the range
+func getLiveBookings(ctx context.Context, q querier, listingID int64, from, to time.Time) ([]*Booking, error) {
+
+	query := `
+		SELECT id, listing_id, guest_id, check_in, check_out, guests, total_price, status, message, version, created_at
+		FROM bookings
+		WHERE listing_id = $1 AND check_in < $3 AND check_out > $2
+		AND status IN ('pending', 'confirmed')
+		ORDER BY check_in
+	`
+
+	rows, err := q.QueryContext(ctx, query, listingID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	bookings := []*Booking{}
+
+	for rows.Next() {
+		booking, err := scanBooking(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		bookings = append(bookings, booking)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bookings, nil
+}
+
+// scanBooking reads a booking from a *sql.Row or *sql.Rows
+func scanBooking(row interface{ Scan(...interface{}) error }) (*Booking, error) {
+
+	var booking Booking
+
+	err := row.Scan(
+		&booking.ID,
+		&booking.ListingID,
+		&booking.GuestID,
+		&booking.CheckIn,
+		&booking.CheckOut,
+		&booking.Guests,
+		&booking.TotalPrice,
+		&booking.Status,
+		&booking.Message,
+		&booking.Version,
+		&booking.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	booking.Nights = nights(booking.CheckIn, booking.CheckOut)
+
+	return &booking, nil
+}
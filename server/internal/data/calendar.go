@@ -0,0 +1,520 @@
+//Filename: internal/data/calendar.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+var (
+	ErrDatesUnavailable  = errors.New("dates are not available")
+	ErrNotVacationRental = errors.New("listing is not a nightly rental")
+)
+
+// the longest range the calendar endpoints will work on
+const maxCalendarNights = 366
+
+// the status of a night on the calendar
+const (
+	NightAvailable   = "available"
+	NightBooked      = "booked"
+	NightPending     = "pending"
+	NightBlocked     = "blocked"
+	NightUnavailable = "unavailable"
+)
+
+// CalendarListing is the part of a listing the calendar needs
+type CalendarListing struct {
+	ID            int64
+	PropertyTitle string
+	ListingType   string
+	RentPeriod    string
+	Price         float64
+	AvailableFrom *time.Time
+}
+
+// IsVacationRental reports if the listing is let by the night
+func (l *CalendarListing) IsVacationRental() bool {
+	return l.ListingType == ListingTypeRent && l.RentPeriod == "nightly"
+}
+
+// NightlyRate overrides the listing price for the nights from StartDate up to, not including, EndDate
+type NightlyRate struct {
+	ID          int64     `json:"id"`
+	ListingID   int64     `json:"listing_id"`
+	StartDate   time.Time `json:"start_date"`
+	EndDate     time.Time `json:"end_date"`
+	NightlyRate float64   `json:"nightly_rate"`
+	CreatedAt   time.Time `json:"-"`
+}
+
+// BlockedDates closes the nights from StartDate up to, not including, EndDate
+type BlockedDates struct {
+	ID        int64     `json:"id"`
+	ListingID int64     `json:"listing_id"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	Reason    string    `json:"reason"`
+	Source    string    `json:"source"`
+	UID       string    `json:"-"`
+	CreatedAt time.Time `json:"-"`
+}
+
+// CalendarDay is a single night of the availability calendar
+type CalendarDay struct {
+	Date        time.Time `json:"date"`
+	Status      string    `json:"status"`
+	Available   bool      `json:"available"`
+	NightlyRate float64   `json:"nightly_rate"`
+}
+
+// the source of blocks added through the api rather than an ical import
+const BlockSourceManual = "manual"
+
+// ValidateDateRange checks a range of nights, the end date is the morning after the last night
+func ValidateDateRange(v *validator.Validator, startKey, endKey string, start, end time.Time) {
+	v.Check(!start.IsZero(), startKey, "must be provided")
+	v.Check(!end.IsZero(), endKey, "must be provided")
+
+	if !start.IsZero() && !end.IsZero() {
+		v.Check(end.After(start), endKey, "must be after "+startKey)
+		v.Check(nights(start, end) <= maxCalendarNights, endKey, "must be within a year of "+startKey)
+	}
+}
+
+func ValidateNightlyRate(v *validator.Validator, rate *NightlyRate) {
+	ValidateDateRange(v, "start_date", "end_date", rate.StartDate, rate.EndDate)
+	v.Check(rate.NightlyRate > 0, "nightly_rate", "must be greater than zero")
+}
+
+func ValidateBlockedDates(v *validator.Validator, block *BlockedDates) {
+	ValidateDateRange(v, "start_date", "end_date", block.StartDate, block.EndDate)
+	v.Check(len(block.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// nights counts the nights between two dates
+func nights(start, end time.Time) int {
+	return int(end.Sub(start).Hours()/24 + 0.5)
+}
+
+// rateFor returns the nightly rate of a date, the most recently added rate covering
+// the date wins and the listing price is used when none do
+func rateFor(listing *CalendarListing, rates []*NightlyRate, date time.Time) float64 {
+	for i := len(rates) - 1; i >= 0; i-- {
+		if !date.Before(rates[i].StartDate) && date.Before(rates[i].EndDate) {
+			return rates[i].NightlyRate
+		}
+	}
+	return listing.Price
+}
+
+// QuoteStay returns the price of the nights from checkIn up to checkOut
+func QuoteStay(listing *CalendarListing, rates []*NightlyRate, checkIn, checkOut time.Time) float64 {
+	total := 0.0
+	for date := checkIn; date.Before(checkOut); date = date.AddDate(0, 0, 1) {
+		total += rateFor(listing, rates, date)
+	}
+	return round2(total)
+}
+
+// BuildCalendar lays out the nights from `from` up to `to`, bookings take
+// precedence over blocks when both cover a night
+func BuildCalendar(listing *CalendarListing, from, to time.Time, rates []*NightlyRate, blocks []*BlockedDates, bookings []*Booking) []*CalendarDay {
+
+	days := []*CalendarDay{}
+
+	for date := from; date.Before(to); date = date.AddDate(0, 0, 1) {
+		day := &CalendarDay{
+			Date:        date,
+			Status:      NightAvailable,
+			NightlyRate: rateFor(listing, rates, date),
+		}
+
+		if listing.AvailableFrom != nil && date.Before(*listing.AvailableFrom) {
+			day.Status = NightUnavailable
+		}
+
+		for _, block := range blocks {
+			if !date.Before(block.StartDate) && date.Before(block.EndDate) {
+				day.Status = NightBlocked
+			}
+		}
+
+		for _, booking := range bookings {
+			if !date.Before(booking.CheckIn) && date.Before(booking.CheckOut) {
+				day.Status = NightBooked
+				if booking.Status == BookingPending {
+					day.Status = NightPending
+				}
+			}
+		}
+
+		day.Available = day.Status == NightAvailable
+		days = append(days, day)
+	}
+
+	return days
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx so reads can join a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Define a CalendarModel which wrap a sql.DB connection pool
+type CalendarModel struct {
+	DB *sql.DB
+}
+
+// GetListing() returns the pricing and availability terms of a listing
+func (m CalendarModel) GetListing(listingID int64) (*CalendarListing, error) {
+
+	//Ensure that there is a valid id
+	if listingID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getCalendarListing(ctx, m.DB, listingID, false)
+}
+
+// getCalendarListing reads a listing, forUpdate locks the row until the
+// transaction ends so that bookings of one listing are made one at a time
+func getCalendarListing(ctx context.Context, q querier, listingID int64, forUpdate bool) (*CalendarListing, error) {
+
+	query := `
+		SELECT id, propertytitle, listing_type, rent_period, price, available_from
+		FROM listing
+		WHERE id = $1
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var listing CalendarListing
+
+	err := q.QueryRowContext(ctx, query, listingID).Scan(
+		&listing.ID,
+		&listing.PropertyTitle,
+		&listing.ListingType,
+		&listing.RentPeriod,
+		&listing.Price,
+		&listing.AvailableFrom,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &listing, nil
+}
+
+// GetCalendar() returns the availability of the nights from `from` up to `to`
+func (m CalendarModel) GetCalendar(listing *CalendarListing, from, to time.Time) ([]*CalendarDay, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rates, err := getRates(ctx, m.DB, listing.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := getBlocks(ctx, m.DB, listing.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	bookings, err := getLiveBookings(ctx, m.DB, listing.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildCalendar(listing, from, to, rates, blocks, bookings), nil
+}
+
+// InsertRate() adds a nightly rate, it takes precedence over older rates for the same nights
+func (m CalendarModel) InsertRate(rate *NightlyRate) error {
+
+	query := `
+		INSERT INTO nightly_rates(listing_id, start_date, end_date, nightly_rate)
+		VALUES($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{rate.ListingID, rate.StartDate, rate.EndDate, rate.NightlyRate}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&rate.ID, &rate.CreatedAt)
+}
+
+// getRates returns the rates covering any night of the range, oldest first
+func getRates(ctx context.Context, q querier, listingID int64, from, to time.Time) ([]*NightlyRate, error) {
+
+	query := `
+		SELECT id, listing_id, start_date, end_date, nightly_rate, created_at
+		FROM nightly_rates
+		WHERE listing_id = $1 AND start_date < $3 AND end_date > $2
+		ORDER BY created_at, id
+	`
+
+	rows, err := q.QueryContext(ctx, query, listingID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rates := []*NightlyRate{}
+
+	for rows.Next() {
+		var rate NightlyRate
+		err := rows.Scan(
+			&rate.ID,
+			&rate.ListingID,
+			&rate.StartDate,
+			&rate.EndDate,
+			&rate.NightlyRate,
+			&rate.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, &rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// InsertBlock() closes a range of nights, it fails with ErrDatesUnavailable when
+// a pending or confirmed booking already holds any of them
+func (m CalendarModel) InsertBlock(block *BlockedDates) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//lock the listing so a booking cannot slip in between the check and the insert
+	_, err = getCalendarListing(ctx, tx, block.ListingID, true)
+	if err != nil {
+		return err
+	}
+
+	bookings, err := getLiveBookings(ctx, tx, block.ListingID, block.StartDate, block.EndDate)
+	if err != nil {
+		return err
+	}
+
+	if len(bookings) > 0 {
+		return ErrDatesUnavailable
+	}
+
+	err = insertBlock(ctx, tx, block)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertBlock(ctx context.Context, tx *sql.Tx, block *BlockedDates) error {
+
+	query := `
+		INSERT INTO blocked_dates(listing_id, start_date, end_date, reason, source, uid)
+		VALUES($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	args := []interface{}{block.ListingID, block.StartDate, block.EndDate, block.Reason, block.Source, block.UID}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&block.ID, &block.CreatedAt)
+}
+
+// ReplaceImportedBlocks() swaps the blocks of an earlier import from the same
+// source for the new ones, so re-importing a feed removes cancelled events
+func (m CalendarModel) ReplaceImportedBlocks(listingID int64, source string, blocks []*BlockedDates) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM blocked_dates WHERE listing_id = $1 AND source = $2`, listingID, source)
+	if err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		block.ListingID = listingID
+		block.Source = source
+
+		err = insertBlock(ctx, tx, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetBlockListing() returns the listing of a block that was added by hand
+func (m CalendarModel) GetBlockListing(id int64) (int64, error) {
+
+	//Ensure that there is a valid id
+	if id < 1 {
+		return 0, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT listing_id
+		FROM blocked_dates
+		WHERE id = $1 AND source = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var listingID int64
+
+	err := m.DB.QueryRowContext(ctx, query, id, BlockSourceManual).Scan(&listingID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return listingID, nil
+}
+
+// DeleteBlock() reopens the nights of a manual block
+func (m CalendarModel) DeleteBlock(id int64) error {
+
+	//Ensure that there is a valid id
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM blocked_dates
+		WHERE id = $1 AND source = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, BlockSourceManual)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetManualBlocks() returns the blocks that were not imported and have not ended, for ical export
+func (m CalendarModel) GetManualBlocks(listingID int64) ([]*BlockedDates, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	blocks, err := getBlocks(ctx, m.DB, listingID, time.Now().UTC().Truncate(24*time.Hour), time.Now().UTC().AddDate(2, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	manual := []*BlockedDates{}
+	for _, block := range blocks {
+		if block.Source == BlockSourceManual {
+			manual = append(manual, block)
+		}
+	}
+
+	return manual, nil
+}
+
+// getBlocks returns the blocks covering any night of the range
+func getBlocks(ctx context.Context, q querier, listingID int64, from, to time.Time) ([]*BlockedDates, error) {
+
+	query := `
+		SELECT id, listing_id, start_date, end_date, reason, source, uid, created_at
+		FROM blocked_dates
+		WHERE listing_id = $1 AND start_date < $3 AND end_date > $2
+		ORDER BY start_date, id
+	`
+
+	rows, err := q.QueryContext(ctx, query, listingID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	blocks := []*BlockedDates{}
+
+	for rows.Next() {
+		var block BlockedDates
+		err := rows.Scan(
+			&block.ID,
+			&block.ListingID,
+			&block.StartDate,
+			&block.EndDate,
+			&block.Reason,
+			&block.Source,
+			&block.UID,
+			&block.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, &block)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
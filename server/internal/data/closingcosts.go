@@ -0,0 +1,209 @@
+//Filename: internal/data/closingcosts.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// ClosingCostRule holds the transfer tax and fees for a type of buyer, rates are percentages
+type ClosingCostRule struct {
+	ID                 int64     `json:"id"`
+	BuyerType          string    `json:"buyer_type"`
+	TransferTaxRate    float64   `json:"transfer_tax_rate"`
+	ExemptionThreshold float64   `json:"exemption_threshold"`
+	LegalFeeRate       float64   `json:"legal_fee_rate"`
+	TitleSearchFee     float64   `json:"title_search_fee"`
+	Version            int32     `json:"version"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// ClosingCostItem is a single line of the estimate
+type ClosingCostItem struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+}
+
+// ClosingCostEstimate itemizes what a buyer pays on top of the price
+type ClosingCostEstimate struct {
+	BuyerType     string            `json:"buyer_type"`
+	Price         float64           `json:"price"`
+	Items         []ClosingCostItem `json:"items"`
+	TotalCosts    float64           `json:"total_costs"`
+	TotalWithCost float64           `json:"total_with_costs"`
+}
+
+// the buyer types a rule can be written for
+var BuyerTypes = []string{"resident", "foreign"}
+
+func ValidateClosingCostRule(v *validator.Validator, rule *ClosingCostRule) {
+	v.Check(validator.In(rule.BuyerType, BuyerTypes...), "buyer_type", "must be resident or foreign")
+
+	v.Check(rule.TransferTaxRate >= 0, "transfer_tax_rate", "must not be negative")
+	v.Check(rule.TransferTaxRate <= 100, "transfer_tax_rate", "must not be more than 100 percent")
+
+	v.Check(rule.ExemptionThreshold >= 0, "exemption_threshold", "must not be negative")
+
+	v.Check(rule.LegalFeeRate >= 0, "legal_fee_rate", "must not be negative")
+	v.Check(rule.LegalFeeRate <= 100, "legal_fee_rate", "must not be more than 100 percent")
+
+	v.Check(rule.TitleSearchFee >= 0, "title_search_fee", "must not be negative")
+}
+
+// Estimate() applies the rule to a price, transfer tax is only charged on the
+// part of the price above the exemption threshold
+func (rule *ClosingCostRule) Estimate(price float64) *ClosingCostEstimate {
+
+	taxable := math.Max(0, price-rule.ExemptionThreshold)
+
+	estimate := &ClosingCostEstimate{
+		BuyerType: rule.BuyerType,
+		Price:     price,
+		Items: []ClosingCostItem{
+			{Name: "transfer_tax", Amount: round2(taxable * rule.TransferTaxRate / 100)},
+			{Name: "legal_fees", Amount: round2(price * rule.LegalFeeRate / 100)},
+			{Name: "title_search_fee", Amount: round2(rule.TitleSearchFee)},
+		},
+	}
+
+	for _, item := range estimate.Items {
+		estimate.TotalCosts += item.Amount
+	}
+
+	estimate.TotalCosts = round2(estimate.TotalCosts)
+	estimate.TotalWithCost = round2(price + estimate.TotalCosts)
+
+	return estimate
+}
+
+// Define a ClosingCostRuleModel which wrap a sql.DB connection pool
+type ClosingCostRuleModel struct {
+	DB *sql.DB
+}
+
+// GetAll() returns the rule for every buyer type
+func (m ClosingCostRuleModel) GetAll() ([]*ClosingCostRule, error) {
+
+	query := `
+		SELECT id, buyer_type, transfer_tax_rate, exemption_threshold, legal_fee_rate, title_search_fee, version, updated_at
+		FROM closing_cost_rules
+		ORDER BY buyer_type
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rules := []*ClosingCostRule{}
+
+	for rows.Next() {
+		var rule ClosingCostRule
+		err := rows.Scan(
+			&rule.ID,
+			&rule.BuyerType,
+			&rule.TransferTaxRate,
+			&rule.ExemptionThreshold,
+			&rule.LegalFeeRate,
+			&rule.TitleSearchFee,
+			&rule.Version,
+			&rule.UpdatedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, &rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Get() returns the rule for a buyer type
+func (m ClosingCostRuleModel) Get(buyerType string) (*ClosingCostRule, error) {
+
+	query := `
+		SELECT id, buyer_type, transfer_tax_rate, exemption_threshold, legal_fee_rate, title_search_fee, version, updated_at
+		FROM closing_cost_rules
+		WHERE buyer_type = $1
+	`
+
+	var rule ClosingCostRule
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, buyerType).Scan(
+		&rule.ID,
+		&rule.BuyerType,
+		&rule.TransferTaxRate,
+		&rule.ExemptionThreshold,
+		&rule.LegalFeeRate,
+		&rule.TitleSearchFee,
+		&rule.Version,
+		&rule.UpdatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rule, nil
+}
+
+// Update() saves a rule, the version check stops two admins overwriting each other
+func (m ClosingCostRuleModel) Update(rule *ClosingCostRule) error {
+
+	query := `
+		UPDATE closing_cost_rules
+		SET transfer_tax_rate = $1, exemption_threshold = $2, legal_fee_rate = $3, title_search_fee = $4,
+		version = version + 1, updated_at = NOW()
+		WHERE buyer_type = $5 AND version = $6
+		RETURNING version, updated_at
+	`
+
+	args := []interface{}{
+		rule.TransferTaxRate,
+		rule.ExemptionThreshold,
+		rule.LegalFeeRate,
+		rule.TitleSearchFee,
+		rule.BuyerType,
+		rule.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&rule.Version, &rule.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+//Filename: internal/data/closings.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+var (
+	ErrDuplicateClosing = errors.New("listing already has a closing record")
+)
+
+// Closing records what a listing actually sold or leased for
+type Closing struct {
+	ID              int64     `json:"id"`
+	ListingID       int64     `json:"listing_id"`
+	SalePrice       float64   `json:"sale_price"`
+	CloseDate       time.Time `json:"close_date"`
+	BuyerAgentID    *int64    `json:"buyer_agent_id,omitempty"`
+	LeaseTermMonths *int32    `json:"lease_term_months,omitempty"`
+	CreatedAt       time.Time `json:"-"`
+}
+
+// IsLease reports if the closing is for a rental rather than a sale
+func (c *Closing) IsLease() bool {
+	return c.LeaseTermMonths != nil
+}
+
+// the status the listing is moved to once the closing is recorded
+func (c *Closing) listingStatus() string {
+	if c.IsLease() {
+		return "Leased"
+	}
+	return "Sold"
+}
+
+func ValidateClosing(v *validator.Validator, closing *Closing) {
+
+	v.Check(closing.ListingID > 0, "listing_id", "must be provided")
+
+	v.Check(closing.SalePrice > 0, "sale_price", "must be greater than zero")
+
+	v.Check(!closing.CloseDate.IsZero(), "close_date", "must be provided")
+	v.Check(closing.CloseDate.Before(time.Now().AddDate(0, 0, 1)), "close_date", "must not be in the future")
+
+	if closing.BuyerAgentID != nil {
+		v.Check(*closing.BuyerAgentID > 0, "buyer_agent_id", "must be a valid user id")
+	}
+
+	if closing.LeaseTermMonths != nil {
+		v.Check(*closing.LeaseTermMonths > 0, "lease_term_months", "must be greater than zero")
+		v.Check(*closing.LeaseTermMonths <= 120, "lease_term_months", "must not be more than 120 months")
+	}
+}
+
+// ValidateClosingType() makes the closing match its listing, a rental is leased for a
+// term and a sale is sold outright
+func ValidateClosingType(v *validator.Validator, closing *Closing, listingType string) {
+
+	if listingType == ListingTypeRent {
+		v.Check(closing.LeaseTermMonths != nil, "lease_term_months", "must be provided for a rental")
+		return
+	}
+
+	v.Check(closing.LeaseTermMonths == nil, "lease_term_months", "must not be provided for a sale")
+}
+
+// Define a ClosingModel which wrap a sql.DB connection pool
+type ClosingModel struct {
+	DB *sql.DB
+}
+
+// Insert() records the closing and moves the listing to Sold/Leased in one transaction
+func (m ClosingModel) Insert(closing *Closing) error {
+
+	query := `
+		INSERT INTO closings(listing_id, sale_price, close_date, buyer_agent_id, lease_term_months)
+		VALUES($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	args := []interface{}{
+		closing.ListingID,
+		closing.SalePrice,
+		closing.CloseDate,
+		closing.BuyerAgentID,
+		closing.LeaseTermMonths,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&closing.ID, &closing.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "closings_listing_id_key"`:
+			return ErrDuplicateClosing
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE listing
+		SET propertystatusid = (select id from propertystatus where name = $1),
+		off_market_at = COALESCE(off_market_at, $3)
+		WHERE id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, closing.listingStatus(), closing.ListingID, closing.CloseDate)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetForListing() returns the closing record of a listing
+func (m ClosingModel) GetForListing(listingID int64) (*Closing, error) {
+
+	//Ensure that there is a valid id
+	if listingID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, listing_id, sale_price, close_date, buyer_agent_id, lease_term_months, created_at
+		FROM closings
+		WHERE listing_id = $1
+	`
+
+	var closing Closing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, listingID).Scan(
+		&closing.ID,
+		&closing.ListingID,
+		&closing.SalePrice,
+		&closing.CloseDate,
+		&closing.BuyerAgentID,
+		&closing.LeaseTermMonths,
+		&closing.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &closing, nil
+}
@@ -0,0 +1,162 @@
+// Filename: internal/data/dataexports.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DataExport is a zip of a user's personal data waiting to be downloaded
+type DataExport struct {
+	ID        int64
+	Plaintext string
+	UserID    int64
+	Path      string
+	Expiry    time.Time
+	CreatedAt time.Time
+}
+
+// Define a DataExportModel which wrap a sql.DB connection pool
+type DataExportModel struct {
+	DB *sql.DB
+}
+
+// New() saves an export for the user with a new single use download token, the zip
+// is written to path afterwards
+func (m DataExportModel) New(userID int64, path string, ttl time.Duration) (*DataExport, error) {
+
+	token, err := generateTokenT(userID, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+
+	export := &DataExport{
+		Plaintext: token.Plaintext,
+		UserID:    userID,
+		Path:      path,
+		Expiry:    token.Expiry,
+	}
+
+	query := `
+		INSERT INTO data_exports(user_id, hash, path, expiry)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, userID, token.Hash, path, export.Expiry).Scan(&export.ID, &export.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// LastRequested() returns when the user last asked for an export, nil if never
+func (m DataExportModel) LastRequested(userID int64) (*time.Time, error) {
+	query := `
+		SELECT max(created_at)
+		FROM data_exports
+		WHERE user_id = $1
+	`
+
+	var last *time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&last)
+
+	return last, err
+}
+
+// Consume() returns the path of the export for the token and forgets it, so the
+// download link only works once
+func (m DataExportModel) Consume(tokenPlainText string) (string, error) {
+	query := `
+		DELETE FROM data_exports
+		WHERE hash = $1
+		RETURNING path, expiry > NOW()
+	`
+
+	var path string
+	var live bool
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, TokenHash(tokenPlainText)).Scan(&path, &live)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	if !live {
+		return path, ErrRecordNotFound
+	}
+
+	return path, nil
+}
+
+// Delete() removes an export that could not be built
+func (m DataExportModel) Delete(id int64) error {
+	query := `
+		DELETE FROM data_exports
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+
+	return err
+}
+
+// DeleteExpired() removes the exports that were never downloaded and returns their
+// paths so the files can be removed too
+func (m DataExportModel) DeleteExpired() ([]string, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return deletePaths(ctx, m.DB, `DELETE FROM data_exports WHERE expiry < NOW() RETURNING path`)
+}
+
+// deletePaths runs a DELETE ... RETURNING of file paths
+func deletePaths(ctx context.Context, q querier, query string, args ...interface{}) ([]string, error) {
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+
+	for rows.Next() {
+		var path string
+
+		err := rows.Scan(&path)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,96 @@
+// Filename: internal/data/denylist.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DenylistEntry revokes signed access tokens before they expire. It holds either
+// the id of one token or a user whose tokens issued before CreatedAt are revoked
+type DenylistEntry struct {
+	JTI       string
+	UserID    int64
+	CreatedAt time.Time
+	Expiry    time.Time
+}
+
+type DenylistModel struct {
+	DB *sql.DB
+}
+
+// DenyToken() revokes a single signed token until it would have expired anyway
+func (m DenylistModel) DenyToken(jti string, expiry time.Time) error {
+	query := `
+		INSERT INTO token_denylist(jti, expiry)
+		VALUES($1, $2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, jti, expiry)
+	return err
+}
+
+// DenyUser() revokes every signed token the user holds, returning when the revocation
+// starts to the microsecond
+func (m DenylistModel) DenyUser(userID int64, expiry time.Time) (time.Time, error) {
+	query := `
+		INSERT INTO token_denylist(user_id, expiry)
+		VALUES($1, $2)
+		RETURNING created_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var createdAt time.Time
+	err := m.DB.QueryRowContext(ctx, query, userID, expiry).Scan(&createdAt)
+
+	return createdAt, err
+}
+
+// GetActive() returns the entries that have not expired and clears out the rest
+func (m DenylistModel) GetActive() ([]*DenylistEntry, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM token_denylist WHERE expiry <= NOW()`)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT COALESCE(jti, ''), COALESCE(user_id, 0), created_at, expiry
+		FROM token_denylist
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	entries := []*DenylistEntry{}
+	for rows.Next() {
+		var entry DenylistEntry
+		err := rows.Scan(&entry.JTI, &entry.UserID, &entry.CreatedAt, &entry.Expiry)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -3,8 +3,10 @@
 package data
 
 import (
+	"fmt"
 	"math"
 	"strings"
+	"time"
 
 	"realestatebelize.imerlopez.net/internal/validator"
 )
@@ -56,6 +58,83 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// ReportFilters narrows a report to a date range and an agency, and optionally splits it into groups
+type ReportFilters struct {
+	From           time.Time
+	To             time.Time
+	OrganizationID int64
+	GroupBy        string
+	GroupByList    []string
+}
+
+func ValidateReportFilters(v *validator.Validator, f ReportFilters) {
+	//check that the range is the right way round
+	if !f.From.IsZero() && !f.To.IsZero() {
+		v.Check(!f.To.Before(f.From), "to", "must not be before from")
+	}
+
+	v.Check(f.OrganizationID >= 0, "organization_id", "must be a valid organization")
+
+	//check that the group_by params matches a value in the acceptable list
+	if f.GroupBy != "" {
+		v.Check(validator.In(f.GroupBy, f.GroupByList...), "group_by", "invalid group_by value")
+	}
+}
+
+// The groupColumn() method safely maps the group_by parameter to a sql expression,
+// dateColumn is the column the month and quarter buckets are taken from
+func (f ReportFilters) groupColumn(dateColumn string) string {
+	switch f.GroupBy {
+	case "":
+		return "''"
+	case "month":
+		return fmt.Sprintf(`to_char(date_trunc('month', %s), 'YYYY-MM')`, dateColumn)
+	case "quarter":
+		return fmt.Sprintf(`to_char(date_trunc('quarter', %s), 'YYYY-"Q"Q')`, dateColumn)
+	case "district":
+		return "d.name"
+	case "property_type":
+		return "pt.name"
+	case "agent":
+		return "COALESCE(u.username, '')"
+	case "organization":
+		return "COALESCE(o.name, '')"
+	}
+	panic("unsafe group_by parameter: " + f.GroupBy)
+}
+
+// The groupClause() method leaves the totals ungrouped when no group_by is given
+// so a single row is still returned for an empty range
+func (f ReportFilters) groupClause() string {
+	if f.GroupBy == "" {
+		return ""
+	}
+	return "GROUP BY 1 ORDER BY 1"
+}
+
+// The from() and to() methods return nil for an open ended range
+func (f ReportFilters) from() interface{} {
+	if f.From.IsZero() {
+		return nil
+	}
+	return f.From
+}
+
+func (f ReportFilters) to() interface{} {
+	if f.To.IsZero() {
+		return nil
+	}
+	return f.To
+}
+
+// The organization() method returns nil when the report covers every agency
+func (f ReportFilters) organization() interface{} {
+	if f.OrganizationID == 0 {
+		return nil
+	}
+	return f.OrganizationID
+}
+
 // The Metadata type contains metadata to help with pagination
 type Metadata struct {
 	CurrentPage  int `json:"current_page,omitempty"`
@@ -0,0 +1,327 @@
+// Filename: internal/data/identities.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateIdentity = errors.New("identity is already linked")
+	ErrInvalidOIDCState  = errors.New("invalid or expired sign in state")
+)
+
+// Identity is an account at an outside identity provider linked to a user
+type Identity struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"-"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// OIDCState is what we keep of a sign in while the user is away at the provider
+type OIDCState struct {
+	Provider string
+	Nonce    string
+	Verifier string
+	UserID   *int64
+	// the browser that started the sign in holds this in a cookie, only its hash is kept
+	Binding string
+}
+
+// Define a IdentityModel which wrap a sql.DB connection pool
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// GetUser() returns the user linked to the provider's subject and records the sign in
+func (m IdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+		WITH identity AS (
+			UPDATE user_identities SET last_login_at = NOW()
+			WHERE provider = $1 AND subject = $2
+			RETURNING user_id
+		)
+		SELECT u.id, u.username, u.password_hash, u.fullname, u.email, u.phone, u.address, u.districtid, u.usertypeid, u.activated, u.created_at
+		FROM users u
+		INNER JOIN identity i ON i.user_id = u.id
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Password.hash,
+		&user.Fullname,
+		&user.Email,
+		&user.Phone,
+		&user.Address,
+		&user.DistrictId,
+		&user.UserTypeId,
+		&user.Activated,
+		&user.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Link() ties the provider's subject to a user
+func (m IdentityModel) Link(identity *Identity) error {
+	query := `
+		INSERT INTO user_identities(user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, identity.UserID, identity.Provider, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+
+	return identityError(err)
+}
+
+// CreateUser() makes an activated account with the given roles for a new identity. The
+// account has a random password so it can only be signed into through the provider
+// until the user resets it
+func (m IdentityModel) CreateUser(user *User, identity *Identity, roles ...string) error {
+
+	err := user.Password.Set(randomSecret())
+	if err != nil {
+		return err
+	}
+
+	user.Username = identityUsername(identity.Email)
+	user.Activated = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users(username, password_hash, fullname, email, phone, address, districtid, usertypeid, activated)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`, user.Username, user.Password.hash, user.Fullname, user.Email, user.Phone, user.Address, user.DistrictId, user.UserTypeId, user.Activated).Scan(&user.ID, &user.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+	`, user.ID, pq.Array(roles))
+	if err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO user_identities(user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING id, created_at
+	`, identity.UserID, identity.Provider, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		return identityError(err)
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForUser() returns the identities linked to a user
+func (m IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY provider
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		var identity Identity
+
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// Delete() unlinks the user's identity at a provider
+func (m IdentityModel) Delete(userID int64, provider string) error {
+	query := `
+		DELETE FROM user_identities
+		WHERE user_id = $1 AND provider = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, provider)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// InsertState() remembers a sign in sent to a provider, only the hash of the state is kept
+func (m IdentityModel) InsertState(state string, s *OIDCState, ttl time.Duration) error {
+	query := `
+		INSERT INTO oidc_states(hash, provider, nonce, verifier, user_id, expiry, binding_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//clear out sign ins that were never finished
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_states WHERE expiry < NOW()`)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, query, TokenHash(state), s.Provider, s.Nonce, s.Verifier, s.UserID, time.Now().Add(ttl), TokenHash(s.Binding))
+
+	return err
+}
+
+// ConsumeState() returns and removes the sign in for state, so each state works once.
+// The binding must be the one the sign in was started with
+func (m IdentityModel) ConsumeState(state, provider, binding string) (*OIDCState, error) {
+	query := `
+		DELETE FROM oidc_states
+		WHERE hash = $1
+		RETURNING provider, nonce, verifier, user_id, expiry > NOW(), binding_hash
+	`
+
+	var s OIDCState
+	var live bool
+	var bindingHash []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, TokenHash(state)).Scan(&s.Provider, &s.Nonce, &s.Verifier, &s.UserID, &live, &bindingHash)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidOIDCState
+		default:
+			return nil, err
+		}
+	}
+
+	if !live || s.Provider != provider || subtle.ConstantTimeCompare(bindingHash, TokenHash(binding)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+
+	return &s, nil
+}
+
+func identityError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case strings.HasPrefix(err.Error(), `pq: duplicate key value violates unique constraint "user_identities_`):
+		return ErrDuplicateIdentity
+	default:
+		return err
+	}
+}
+
+// identityUsername makes a username from the email, with a random suffix so it is unique
+func identityUsername(email string) string {
+
+	name := email
+	if at := strings.Index(email, "@"); at > 0 {
+		name = email[:at]
+	}
+	if len(name) > 40 {
+		name = name[:40]
+	}
+
+	b := make([]byte, 3)
+	rand.Read(b)
+
+	return strings.ToLower(name) + "-" + hex.EncodeToString(b)
+}
+
+// randomSecret returns a password nobody knows
+func randomSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+}
@@ -13,34 +13,95 @@ import (
 )
 
 type Listing struct {
-	ID               int64     `json:"id"`
-	PropertyTitle    string    `json:"property_title"`
-	PropertyStatusId int64     `json:"property_status_id"`
-	PropertyTypeId   int64     `json:"property_type_id"`
-	Price            float64   `json:"price"`
-	Description      string    `json:"description"`
-	Address          string    `json:"address"`
-	DistrictId       int64     `json:"district_id"`
-	GoogleMapUrl     string    `json:"google_map_url"`
-	CreatedAt        time.Time `json:"-"`
+	ID               int64   `json:"id"`
+	PropertyTitle    string  `json:"property_title"`
+	PropertyStatusId int64   `json:"property_status_id"`
+	PropertyTypeId   int64   `json:"property_type_id"`
+	Price            float64 `json:"price"`
+	Area             float64 `json:"area"`
+	Description      string  `json:"description"`
+	Address          string  `json:"address"`
+	DistrictId       int64   `json:"district_id"`
+	GoogleMapUrl     string  `json:"google_map_url"`
+	RentalTerms
+	CreatedAt time.Time `json:"-"`
 }
 
 // listing struct for get by id
 type Listings struct {
-	ID               int64     `json:"id"`
-	PropertyTitle    string    `json:"property_title"`
-	PropertyStatusId string    `json:"property_status_id"`
-	PropertyTypeId   string    `json:"property_type_id"`
-	Price            float64   `json:"price"`
-	Description      string    `json:"description"`
-	Address          string    `json:"address"`
-	DistrictId       string    `json:"district_id"`
-	GoogleMapUrl     string    `json:"google_map_url"`
-	Images           []string  `json:"images"`
-	Agent            string    `json:"agent"`
-	AgentPhone       string    `json:"agent_phone"`
-	AgentEmail       string    `json:"agent_email"`
-	CreatedAt        time.Time `json:"-"`
+	ID               int64      `json:"id"`
+	PropertyTitle    string     `json:"property_title"`
+	PropertyStatusId string     `json:"property_status_id"`
+	PropertyTypeId   string     `json:"property_type_id"`
+	Price            float64    `json:"price"`
+	Area             float64    `json:"area"`
+	Description      string     `json:"description"`
+	Address          string     `json:"address"`
+	DistrictId       string     `json:"district_id"`
+	GoogleMapUrl     string     `json:"google_map_url"`
+	Images           []string   `json:"images"`
+	Agent            string     `json:"agent"`
+	AgentPhone       string     `json:"agent_phone"`
+	AgentEmail       string     `json:"agent_email"`
+	ListedAt         *time.Time `json:"listed_at"`
+	OffMarketAt      *time.Time `json:"off_market_at,omitempty"`
+	DaysOnMarket     *int64     `json:"days_on_market"`
+	RentalTerms
+	CreatedAt time.Time `json:"-"`
+}
+
+// the kinds of listing and how often rent is paid
+const (
+	ListingTypeSale = "sale"
+	ListingTypeRent = "rent"
+)
+
+var RentPeriods = []string{"monthly", "weekly", "nightly"}
+
+// RentalTerms are the extra details of a listing for rent, the price of a
+// rental is the rent for one rent period
+type RentalTerms struct {
+	ListingType        string     `json:"listing_type"`
+	RentPeriod         string     `json:"rent_period,omitempty"`
+	Deposit            float64    `json:"deposit,omitempty"`
+	MinimumLeaseMonths int32      `json:"minimum_lease_months,omitempty"`
+	UtilitiesIncluded  bool       `json:"utilities_included"`
+	PetsAllowed        bool       `json:"pets_allowed"`
+	AvailableFrom      *time.Time `json:"available_from,omitempty"`
+}
+
+// IsRental reports if the listing is for rent rather than for sale
+func (t RentalTerms) IsRental() bool {
+	return t.ListingType == ListingTypeRent
+}
+
+// ValidateSaleListing() rejects rentals where only a sale makes sense, a rent has
+// no sale price to value, finance or pay closing costs on
+func ValidateSaleListing(v *validator.Validator, listingType string) {
+	v.Check(listingType != ListingTypeRent, "listing_type", "must be a listing for sale")
+}
+
+// rentals need their rent terms, sales must not have any
+func validateRentalTerms(v *validator.Validator, terms RentalTerms, price float64) {
+
+	v.Check(validator.In(terms.ListingType, ListingTypeSale, ListingTypeRent), "listing_type", "must be sale or rent")
+
+	if terms.IsRental() {
+		v.Check(price > 0, "price", "must be the rent amount for a rental")
+		v.Check(validator.In(terms.RentPeriod, RentPeriods...), "rent_period", "must be monthly, weekly or nightly")
+		v.Check(terms.Deposit >= 0, "deposit", "must not be negative")
+		v.Check(terms.MinimumLeaseMonths >= 0, "minimum_lease_months", "must not be negative")
+		v.Check(terms.MinimumLeaseMonths <= 120, "minimum_lease_months", "must not be more than 120 months")
+		v.Check(terms.AvailableFrom != nil, "available_from", "must be provided for a rental")
+		return
+	}
+
+	v.Check(terms.RentPeriod == "", "rent_period", "must not be set for a sale")
+	v.Check(terms.Deposit == 0, "deposit", "must not be set for a sale")
+	v.Check(terms.MinimumLeaseMonths == 0, "minimum_lease_months", "must not be set for a sale")
+	v.Check(!terms.UtilitiesIncluded, "utilities_included", "must not be set for a sale")
+	v.Check(!terms.PetsAllowed, "pets_allowed", "must not be set for a sale")
+	v.Check(terms.AvailableFrom == nil, "available_from", "must not be set for a sale")
 }
 
 func ValidateListing(v *validator.Validator, listing *Listing) {
@@ -53,6 +114,7 @@ func ValidateListing(v *validator.Validator, listing *Listing) {
 	v.Check(listing.PropertyTypeId > 0, "property_type_id", "must be provided")
 
 	v.Check(listing.Price >= 0, "price", "must be provided")
+	v.Check(listing.Area >= 0, "area", "must not be negative")
 
 	v.Check(listing.Description != "", "description", "must be provided")
 	v.Check(len(listing.Description) >= 20, "description", "must be more than 20 byte long")
@@ -64,6 +126,8 @@ func ValidateListing(v *validator.Validator, listing *Listing) {
 
 	v.Check(listing.GoogleMapUrl != "", "google_map_url", "must be provided")
 
+	validateRentalTerms(v, listing.RentalTerms, listing.Price)
+
 }
 
 func ValidateListings(v *validator.Validator, listing *Listings) {
@@ -76,6 +140,7 @@ func ValidateListings(v *validator.Validator, listing *Listings) {
 	v.Check(listing.PropertyTypeId != "", "property_type_id", "must be provided")
 
 	v.Check(listing.Price >= 0, "price", "must be provided")
+	v.Check(listing.Area >= 0, "area", "must not be negative")
 
 	v.Check(listing.Description != "", "description", "must be provided")
 	v.Check(len(listing.Description) >= 20, "description", "must be more than 20 byte long")
@@ -87,6 +152,8 @@ func ValidateListings(v *validator.Validator, listing *Listings) {
 
 	v.Check(listing.GoogleMapUrl != "", "google_map_url", "must be provided")
 
+	validateRentalTerms(v, listing.RentalTerms, listing.Price)
+
 }
 
 // Define a ListingModel which wrap a sql.DB connection pool
@@ -94,13 +161,22 @@ type ListingModel struct {
 	DB *sql.DB
 }
 
-// insert() allow us to create a new school
-func (m ListingModel) Insert(listing *Listing) error {
+// insert() allow us to create a new listing, it is assigned to the agent creating it
+// so only they (and their broker or an admin) can change it
+func (m ListingModel) Insert(listing *Listing, agentID int64) error {
 
 	query := `
-		INSERT INTO listing(propertytitle,propertystatusid,propertytypeid,price,description,address,districtid,googlemapurl)
-		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, created_at
+		WITH new_listing AS (
+			INSERT INTO listing(propertytitle,propertystatusid,propertytypeid,price,description,address,districtid,googlemapurl,area,
+			listing_type,rent_period,deposit,minimum_lease_months,utilities_included,pets_allowed,available_from,listed_at)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16,
+			CASE WHEN (select name from propertystatus where id = $2) = 'Available' THEN NOW() END)
+			RETURNING id, created_at
+		), agent AS (
+			INSERT INTO userproperties(userid, listingid)
+			SELECT $17, id FROM new_listing
+		)
+		SELECT id, created_at FROM new_listing
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
@@ -116,6 +192,15 @@ func (m ListingModel) Insert(listing *Listing) error {
 		listing.Address,
 		listing.DistrictId,
 		listing.GoogleMapUrl,
+		listing.Area,
+		listing.ListingType,
+		listing.RentPeriod,
+		listing.Deposit,
+		listing.MinimumLeaseMonths,
+		listing.UtilitiesIncluded,
+		listing.PetsAllowed,
+		listing.AvailableFrom,
+		agentID,
 	}
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&listing.ID, &listing.CreatedAt)
@@ -128,9 +213,12 @@ func (m ListingModel) Update(listing *Listings) error {
 	query := `
 	UPDATE listing
 	set propertytitle = $1, propertystatusid = (select id from propertystatus where name = $2), propertytypeid = (select id from propertytype where name = $3)
-	,price = $4, description = $5, address = $6, districtid = (select id from district where name = $7), googlemapurl = $8
+	,price = $4, description = $5, address = $6, districtid = (select id from district where name = $7), googlemapurl = $8, area = $10
+	,listing_type = $11, rent_period = $12, deposit = $13, minimum_lease_months = $14, utilities_included = $15, pets_allowed = $16, available_from = $17
+	,listed_at = CASE WHEN $2 = 'Available' AND (listed_at IS NULL OR off_market_at IS NOT NULL) THEN NOW() ELSE listed_at END
+	,off_market_at = CASE WHEN $2 = 'Available' THEN NULL WHEN off_market_at IS NULL AND listed_at IS NOT NULL THEN NOW() ELSE off_market_at END
 	where id = $9
-		RETURNING id
+		RETURNING id, listed_at, off_market_at
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
@@ -147,9 +235,17 @@ func (m ListingModel) Update(listing *Listings) error {
 		listing.DistrictId,
 		listing.GoogleMapUrl,
 		listing.ID,
+		listing.Area,
+		listing.ListingType,
+		listing.RentPeriod,
+		listing.Deposit,
+		listing.MinimumLeaseMonths,
+		listing.UtilitiesIncluded,
+		listing.PetsAllowed,
+		listing.AvailableFrom,
 	}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&listing.ID)
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&listing.ID, &listing.ListedAt, &listing.OffMarketAt)
 
 }
 
@@ -164,7 +260,9 @@ func (m ListingModel) Get(id int64) (*Listings, error) {
 	//create query
 	query := `
 
-	SELECT l.id , l.propertytitle as title, ps.name as propertystatus, pt.name as propertytype, l.price, l.description, l.address, d.name as district, l.googlemapurl, i.imageurl,u.fullname, u.phone, u.email, l.created_at  from listing l inner join propertystatus ps on l.propertystatusid=ps.id
+	SELECT l.id , l.propertytitle as title, ps.name as propertystatus, pt.name as propertytype, l.price, l.area, l.description, l.address, d.name as district, l.googlemapurl, i.imageurl,u.fullname, u.phone, u.email,
+	l.listed_at, l.off_market_at, (COALESCE(l.off_market_at, NOW())::date - l.listed_at::date) as daysonmarket,
+	l.listing_type, l.rent_period, l.deposit, l.minimum_lease_months, l.utilities_included, l.pets_allowed, l.available_from, l.created_at  from listing l inner join propertystatus ps on l.propertystatusid=ps.id
 	inner join propertytype pt on l.propertytypeid = pt.id
 	inner join district d on l.districtid = d.id
 	inner join userproperties up on up.listingid = l.id
@@ -191,6 +289,7 @@ func (m ListingModel) Get(id int64) (*Listings, error) {
 		&listing.PropertyStatusId,
 		&listing.PropertyTypeId,
 		&listing.Price,
+		&listing.Area,
 		&listing.Description,
 		&listing.Address,
 		&listing.DistrictId,
@@ -199,6 +298,16 @@ func (m ListingModel) Get(id int64) (*Listings, error) {
 		&listing.Agent,
 		&listing.AgentPhone,
 		&listing.AgentEmail,
+		&listing.ListedAt,
+		&listing.OffMarketAt,
+		&listing.DaysOnMarket,
+		&listing.ListingType,
+		&listing.RentPeriod,
+		&listing.Deposit,
+		&listing.MinimumLeaseMonths,
+		&listing.UtilitiesIncluded,
+		&listing.PetsAllowed,
+		&listing.AvailableFrom,
 		&listing.CreatedAt,
 	)
 
@@ -216,13 +325,132 @@ func (m ListingModel) Get(id int64) (*Listings, error) {
 	return &listing, nil
 }
 
+// ListingPrice is the asking price of a listing and if it is for sale or rent
+type ListingPrice struct {
+	ID          int64
+	Price       float64
+	ListingType string
+}
+
+// GetPrice() reads only the price and type of a listing, unlike Get() it finds
+// listings that have no agent or images yet
+func (m ListingModel) GetPrice(id int64) (*ListingPrice, error) {
+
+	//Ensure that there is a valid id
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, price, listing_type
+		FROM listing
+		WHERE id = $1
+	`
+
+	var listing ListingPrice
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&listing.ID, &listing.Price, &listing.ListingType)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &listing, nil
+}
+
+// ListingSearch holds the search filters of the listings endpoint, empty
+// values and nil pointers are not filtered on
+type ListingSearch struct {
+	PropertyTitle     string
+	DistrictId        string
+	ListingType       string
+	RentPeriod        string
+	PetsAllowed       *bool
+	UtilitiesIncluded *bool
+	AvailableBy       *time.Time
+	MinPrice          float64
+	MaxPrice          float64
+	OrganizationID    int64
+}
+
+func ValidateListingSearch(v *validator.Validator, search ListingSearch) {
+	if search.ListingType != "" {
+		v.Check(validator.In(search.ListingType, ListingTypeSale, ListingTypeRent), "listing_type", "must be sale or rent")
+	}
+
+	if search.RentPeriod != "" {
+		v.Check(validator.In(search.RentPeriod, RentPeriods...), "rent_period", "must be monthly, weekly or nightly")
+	}
+
+	v.Check(search.MinPrice >= 0, "min_price", "must not be negative")
+	v.Check(search.MaxPrice >= 0, "max_price", "must not be negative")
+	if search.MaxPrice > 0 {
+		v.Check(search.MaxPrice >= search.MinPrice, "max_price", "must not be less than min_price")
+	}
+}
+
 // Display all listings
-func (m ListingModel) ShowListings(propertytitle string, district string, filters Filters) ([]*Listings, Metadata, error) {
+func (m ListingModel) ShowListings(search ListingSearch, filters Filters) ([]*Listings, Metadata, error) {
+
+	//create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	//cleanup to prevent memory leak
+	defer cancel()
+
+	totalRecords := 0
+
+	//Initialize an empty slice to hold listings data
+	listings := []*Listings{}
+
+	err := m.eachListing(ctx, search, filters, filters.limit(), filters.offset(), func(total int, listing *Listings) error {
+		totalRecords = total
+		//add the listings to our slice
+		listings = append(listings, listing)
+		return nil
+	})
+
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	// return slice of listings
+	return listings, metadata, nil
+
+}
+
+// StreamListings() calls fn for every listing matching the search, ignoring the page
+// so exports can write the rows out as they are read
+func (m ListingModel) StreamListings(search ListingSearch, filters Filters, fn func(*Listings) error) error {
+
+	//exports can be large so they get a longer timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	defer cancel()
+
+	//a NULL limit returns every row
+	return m.eachListing(ctx, search, filters, nil, 0, func(total int, listing *Listings) error {
+		return fn(listing)
+	})
+}
+
+// eachListing() runs the listings search and scans the rows one at a time
+func (m ListingModel) eachListing(ctx context.Context, search ListingSearch, filters Filters, limit interface{}, offset int, fn func(int, *Listings) error) error {
 
 	//create query
 	query := fmt.Sprintf(`
 
-	SELECT COUNT(*) OVER(), l.id , l.propertytitle as title, ps.name as propertystatus, pt.name as propertytype, l.price, l.description, l.address, d.name as district, l.googlemapurl, i.imageurl,u.fullname, u.phone, u.email, l.created_at  from listing l inner join propertystatus ps on l.propertystatusid=ps.id
+	SELECT COUNT(*) OVER(), l.id , l.propertytitle as title, ps.name as propertystatus, pt.name as propertytype, l.price, l.area, l.description, l.address, d.name as district, l.googlemapurl, i.imageurl,u.fullname, u.phone, u.email,
+	l.listed_at, l.off_market_at, (COALESCE(l.off_market_at, NOW())::date - l.listed_at::date) as daysonmarket,
+	l.listing_type, l.rent_period, l.deposit, l.minimum_lease_months, l.utilities_included, l.pets_allowed, l.available_from, l.created_at  from listing l inner join propertystatus ps on l.propertystatusid=ps.id
 	inner join propertytype pt on l.propertytypeid = pt.id
 	inner join district d on l.districtid = d.id
 	inner join userproperties up on up.listingid = l.id
@@ -230,36 +458,47 @@ func (m ListingModel) ShowListings(propertytitle string, district string, filter
 	inner join images i on i.listingid = l.id
 	where (to_tsvector('simple', l.propertytitle) @@ plainto_tsquery('simple', $1) OR $1 = '')
 	AND (to_tsvector('simple', d.name) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	AND (l.listing_type = $3 OR $3 = '')
+	AND (l.rent_period = $4 OR $4 = '')
+	AND (l.pets_allowed = $5 OR $5::bool IS NULL)
+	AND (l.utilities_included = $6 OR $6::bool IS NULL)
+	AND (l.available_from <= $7::date OR $7::date IS NULL)
+	AND (l.price >= $8 OR $8 = 0)
+	AND (l.price <= $9 OR $9 = 0)
+	AND (l.organization_id = $10 OR $10 = 0)
 	ORDER BY %s %s, l.id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortOrder())
+	LIMIT $11 OFFSET $12`, filters.sortColumn(), filters.sortOrder())
 
-	//create a context
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-
-	//cleanup to prevent memory leak
-	defer cancel()
-
-	args := []interface{}{propertytitle, district, filters.limit(), filters.offset()}
+	args := []interface{}{
+		search.PropertyTitle,
+		search.DistrictId,
+		search.ListingType,
+		search.RentPeriod,
+		search.PetsAllowed,
+		search.UtilitiesIncluded,
+		search.AvailableBy,
+		search.MinPrice,
+		search.MaxPrice,
+		search.OrganizationID,
+		limit,
+		offset,
+	}
 	//execute
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 
 	if err != nil {
-		return nil, Metadata{}, err
+		return err
 	}
 
 	//close the result set
 	defer rows.Close()
 
-	totalRecords := 0
-
-	//Initialize an empty slice to hold listings data
-	listings := []*Listings{}
-
 	//iterate over the rows in the result set
 
 	for rows.Next() {
 		var listing Listings
-		//scan the values from row into school struct
+		var totalRecords int
+		//scan the values from row into listing struct
 		err := rows.Scan(
 			&totalRecords,
 			&listing.ID,
@@ -267,6 +506,7 @@ func (m ListingModel) ShowListings(propertytitle string, district string, filter
 			&listing.PropertyStatusId,
 			&listing.PropertyTypeId,
 			&listing.Price,
+			&listing.Area,
 			&listing.Description,
 			&listing.Address,
 			&listing.DistrictId,
@@ -275,26 +515,31 @@ func (m ListingModel) ShowListings(propertytitle string, district string, filter
 			&listing.Agent,
 			&listing.AgentPhone,
 			&listing.AgentEmail,
+			&listing.ListedAt,
+			&listing.OffMarketAt,
+			&listing.DaysOnMarket,
+			&listing.ListingType,
+			&listing.RentPeriod,
+			&listing.Deposit,
+			&listing.MinimumLeaseMonths,
+			&listing.UtilitiesIncluded,
+			&listing.PetsAllowed,
+			&listing.AvailableFrom,
 			&listing.CreatedAt,
 		)
 
 		if err != nil {
-			return nil, Metadata{}, err
+			return err
 		}
 
-		//add the listings to our slice
-		listings = append(listings, &listing)
+		err = fn(totalRecords, &listing)
+		if err != nil {
+			return err
+		}
 
 	}
 
 	//Check for errors after looping through the result set
-
-	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
-	}
-
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	// return slice of listings
-	return listings, metadata, nil
+	return rows.Err()
 
 }
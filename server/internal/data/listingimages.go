@@ -57,12 +57,12 @@ func (m ListingImgModel) Insert(listingimg *ListingImages) error {
 	return nil
 }
 
-// get id from listing recent create
-func (m ListingImgModel) GetByListingId() (int64, error) {
+// get id of the listing the agent created most recently
+func (m ListingImgModel) GetByListingId(agentID int64) (int64, error) {
 
 	query := `
 	
-		SELECT MAX(id) as id FROM listing
+		SELECT COALESCE(MAX(listingid), 0) as id FROM userproperties WHERE userid = $1
 
 	`
 
@@ -71,7 +71,7 @@ func (m ListingImgModel) GetByListingId() (int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query).Scan(
+	err := m.DB.QueryRowContext(ctx, query, agentID).Scan(
 
 		&userpimg.UserID,
 	)
@@ -86,5 +86,9 @@ func (m ListingImgModel) GetByListingId() (int64, error) {
 
 	}
 
+	if userpimg.UserID == 0 {
+		return 0, ErrRecordNotFound
+	}
+
 	return userpimg.UserID, nil
 }
@@ -0,0 +1,209 @@
+// Filename: internal/data/loginfailures.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LoginPolicy says how hard failed logins against a key are slowed down. After
+// FreeAttempts failures each new one doubles the wait, up to MaxDelay, and from the
+// LockAfter'th failure the key is locked for LockFor. Failures older than Window are forgotten
+type LoginPolicy struct {
+	FreeAttempts int
+	MaxDelay     time.Duration
+	LockAfter    int // 0 never locks
+	LockFor      time.Duration
+	Window       time.Duration
+}
+
+// Delay returns the wait after the given number of failures
+func (p LoginPolicy) Delay(failures int) time.Duration {
+
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := time.Second
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// LoginThrottle is whether a login may be tried now
+type LoginThrottle struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// Check() returns the longest wait any of the keys is under
+func (m LoginFailureModel) Check(keys ...string) (*LoginThrottle, error) {
+	query := `
+		SELECT
+		COALESCE(EXTRACT(EPOCH FROM max(next_attempt_at) - NOW()), 0),
+		COALESCE(EXTRACT(EPOCH FROM max(locked_until) - NOW()), 0)
+		FROM login_failures
+		WHERE key = ANY($1)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var wait, locked float64
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&wait, &locked)
+	if err != nil {
+		return nil, err
+	}
+
+	throttle := &LoginThrottle{}
+	if locked > 0 {
+		throttle.Locked = true
+		wait = locked
+	}
+	if wait > 0 {
+		throttle.RetryAfter = time.Duration(wait * float64(time.Second))
+	}
+
+	return throttle, nil
+}
+
+// delays lists the wait after each number of failures up to where it stops growing,
+// the last one holds for any count past the end
+func (p LoginPolicy) delays() []float64 {
+
+	var delays []float64
+	for failures := 1; failures <= p.FreeAttempts+64; failures++ {
+		delay := p.Delay(failures)
+		delays = append(delays, delay.Seconds())
+		if delay >= p.MaxDelay {
+			break
+		}
+	}
+
+	return delays
+}
+
+// Attempt() counts a login attempt against the key before the password or code is
+// checked, unless the key is waiting out its backoff or locked. The wait is checked and
+// the attempt counted in one statement so concurrent attempts cannot all get past the
+// backoff. It returns nil when the attempt may go ahead
+func (m LoginFailureModel) Attempt(key string, policy LoginPolicy) (*LoginThrottle, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//the timestamps are kept to the second, so the wait starts at the current second
+	//or a retry in the same second would be held up
+	query := `
+		INSERT INTO login_failures(key, failures, last_failure_at, next_attempt_at)
+		VALUES($1, 1, NOW(), date_trunc('second', NOW()) + make_interval(secs => ($3::float8[])[1]))
+		ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $2)
+			THEN 1 ELSE login_failures.failures + 1 END,
+		last_failure_at = NOW(),
+		next_attempt_at = date_trunc('second', NOW()) + make_interval(secs => ($3::float8[])[LEAST(
+			CASE WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $2)
+			THEN 1 ELSE login_failures.failures + 1 END, cardinality($3::float8[]))])
+		WHERE login_failures.next_attempt_at <= NOW()
+		AND (login_failures.locked_until IS NULL OR login_failures.locked_until <= NOW())
+		RETURNING failures
+	`
+
+	var failures int
+	err := m.DB.QueryRowContext(ctx, query, key, policy.Window.Seconds(), pq.Array(policy.delays())).Scan(&failures)
+
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	//the key is waiting, find out for how long
+	throttle, err := m.Check(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if throttle.RetryAfter < time.Second {
+		throttle.RetryAfter = time.Second
+	}
+
+	return throttle, nil
+}
+
+// RecordFailure() is called when a counted attempt failed. It locks the key once it has
+// LockAfter failures and is not locked already, so every failure after a lock runs out
+// locks it again, and reports whether this failure locked it
+func (m LoginFailureModel) RecordFailure(key string, policy LoginPolicy) (bool, error) {
+
+	if policy.LockAfter == 0 {
+		return false, nil
+	}
+
+	query := `
+		UPDATE login_failures SET locked_until = NOW() + make_interval(secs => $3)
+		WHERE key = $1 AND failures >= $2
+		AND (locked_until IS NULL OR locked_until <= NOW())
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, key, policy.LockAfter, policy.LockFor.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// Forgive() takes back a counted attempt that succeeded, for keys like addresses that
+// are not reset by a good login
+func (m LoginFailureModel) Forgive(key string, policy LoginPolicy) error {
+	query := `
+		UPDATE login_failures SET failures = GREATEST(failures - 1, 0),
+		next_attempt_at = LEAST(next_attempt_at, date_trunc('second', NOW()) + make_interval(secs => ($2::float8[])[LEAST(
+			GREATEST(failures - 1, 1), cardinality($2::float8[]))]))
+		WHERE key = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, pq.Array(policy.delays()))
+	return err
+}
+
+// Reset() forgets the failures of a key, after a good login or an unlock
+func (m LoginFailureModel) Reset(key string) error {
+	query := `
+		DELETE FROM login_failures WHERE key = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
@@ -0,0 +1,333 @@
+// Filename: internal/data/mfa.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrMFAEnabled    = errors.New("two-factor authentication already enabled")
+	ErrMFANotEnabled = errors.New("two-factor authentication not enabled")
+)
+
+// how many recovery codes a user gets
+const recoveryCodeCount = 10
+
+// MFA is the TOTP enrollment of a user
+type MFA struct {
+	UserID   int64
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+type MFAModel struct {
+	DB *sql.DB
+}
+
+// GenerateRecoveryCodes returns new recovery codes written as xxxxx-xxxxx
+func GenerateRecoveryCodes() ([]string, error) {
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode ignores case and the dash so codes can be typed loosely
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// Get() returns the enrollment of the user
+func (m MFAModel) Get(userID int64) (*MFA, error) {
+	query := `
+		SELECT user_id, secret, enabled, last_step
+		FROM user_mfa
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mfa MFA
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&mfa.UserID, &mfa.Secret, &mfa.Enabled, &mfa.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &mfa, nil
+}
+
+// IsEnabled() reports whether the user signs in with a second factor
+func (m MFAModel) IsEnabled(userID int64) (bool, error) {
+	query := `
+		SELECT EXISTS (select 1 from user_mfa where user_id = $1 and enabled)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&enabled)
+
+	return enabled, err
+}
+
+// Enroll() starts enrollment with a new secret, starting again replaces the secret
+// of an unconfirmed enrollment
+func (m MFAModel) Enroll(userID int64, secret string) error {
+	query := `
+		INSERT INTO user_mfa(user_id, secret)
+		VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = NOW()
+		WHERE NOT user_mfa.enabled
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFAEnabled
+	}
+
+	return nil
+}
+
+// Enable() confirms the enrollment and stores the hashes of the recovery codes
+func (m MFAModel) Enable(userID, step int64, recoveryCodes []string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_mfa SET enabled = true, enabled_at = NOW(), last_step = $2
+		WHERE user_id = $1 AND NOT enabled
+	`
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFAEnabled
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes() swaps the user's recovery codes for new ones
+func (m MFAModel) ReplaceRecoveryCodes(userID int64, recoveryCodes []string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, recoveryCodes []string) error {
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	hashes := make([][]byte, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	query := `
+		INSERT INTO mfa_recovery_codes(user_id, hash)
+		SELECT $1, unnest($2::bytea[])
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(hashes))
+	return err
+}
+
+// UseStep() records that a TOTP step was used, it returns false when the step or a
+// later one was used before so a code cannot be replayed
+func (m MFAModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+		UPDATE user_mfa SET last_step = $2
+		WHERE user_id = $1 AND last_step < $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode() spends a recovery code, it returns false when the code is unknown or used
+func (m MFAModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+		UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// RemainingRecoveryCodes() counts the unused recovery codes of the user
+func (m MFAModel) RemainingRecoveryCodes(userID int64) (int, error) {
+	query := `
+		SELECT count(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+
+	return count, err
+}
+
+// Disable() removes the enrollment and recovery codes of the user
+func (m MFAModel) Disable(userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFANotEnabled
+	}
+
+	return tx.Commit()
+}
+
+// CountAttempt() records a failed second factor against an mfa token and returns
+// how many have failed so far
+func (m TokenModel) CountAttempt(tokenPlainText string) (int, error) {
+	query := `
+		UPDATE tokens SET attempts = attempts + 1
+		WHERE hash = $1 AND scope = $2
+		RETURNING attempts
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var attempts int
+	err := m.DB.QueryRowContext(ctx, query, TokenHash(tokenPlainText), ScopeMFA).Scan(&attempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return attempts, nil
+}
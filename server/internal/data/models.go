@@ -15,15 +15,32 @@ var (
 // A wrapper for our data models
 type Models struct {
 	Tokens           TokenModel
+	Denylist         DenylistModel
+	MFA              MFAModel
+	LoginFailures    LoginFailureModel
+	Audit            AuditModel
+	APIKeys          APIKeyModel
+	Identities       IdentityModel
+	Notifications    NotificationModel
+	DataExports      DataExportModel
 	Users            UserModel
 	UserProfileImage UserProfileImgModel
 	Listing          ListingModel
 	Permissions      PermissionsModel
+	Roles            RoleModel
 	UserListings     UserListingsModel
 	ListingImages    ListingImgModel
 	TopAgents        ReportModel
 	ListingsStatus   ReportModel
 	TotalSales       ReportModel
+	InventoryAging   ReportModel
+	Closings         ClosingModel
+	ReportJobs       ReportJobModel
+	Valuation        ValuationModel
+	ClosingCostRules ClosingCostRuleModel
+	Calendar         CalendarModel
+	Bookings         BookingModel
+	Organizations    OrganizationModel
 }
 
 // NewModels allow us to create a new models
@@ -31,14 +48,31 @@ func NewModels(db *sql.DB) Models {
 
 	return Models{
 		Tokens:           TokenModel{DB: db},
+		Denylist:         DenylistModel{DB: db},
+		MFA:              MFAModel{DB: db},
+		LoginFailures:    LoginFailureModel{DB: db},
+		Audit:            AuditModel{DB: db},
+		APIKeys:          APIKeyModel{DB: db},
+		Identities:       IdentityModel{DB: db},
+		Notifications:    NotificationModel{DB: db},
+		DataExports:      DataExportModel{DB: db},
 		Users:            UserModel{DB: db},
 		UserProfileImage: UserProfileImgModel{DB: db},
 		Listing:          ListingModel{DB: db},
 		Permissions:      PermissionsModel{DB: db},
+		Roles:            RoleModel{DB: db},
 		UserListings:     UserListingsModel{DB: db},
 		ListingImages:    ListingImgModel{DB: db},
 		TopAgents:        ReportModel{DB: db},
 		ListingsStatus:   ReportModel{DB: db},
 		TotalSales:       ReportModel{DB: db},
+		InventoryAging:   ReportModel{DB: db},
+		Closings:         ClosingModel{DB: db},
+		ReportJobs:       ReportJobModel{DB: db},
+		Valuation:        ValuationModel{DB: db},
+		ClosingCostRules: ClosingCostRuleModel{DB: db},
+		Calendar:         CalendarModel{DB: db},
+		Bookings:         BookingModel{DB: db},
+		Organizations:    OrganizationModel{DB: db},
 	}
 }
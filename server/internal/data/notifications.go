@@ -0,0 +1,104 @@
+// Filename: internal/data/notifications.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NotificationPreferences are the optional emails a user gets. Account and security
+// emails are always sent
+type NotificationPreferences struct {
+	ReportDigests bool `json:"report_digests"`
+}
+
+// DefaultNotificationPreferences are used until the user changes them
+var DefaultNotificationPreferences = NotificationPreferences{
+	ReportDigests: true,
+}
+
+// Define a NotificationModel which wrap a sql.DB connection pool
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+// GetForUser() returns the user's preferences, or the defaults if they have none saved
+func (m NotificationModel) GetForUser(userID int64) (NotificationPreferences, error) {
+	query := `
+		SELECT report_digests
+		FROM notification_preferences
+		WHERE user_id = $1
+	`
+
+	prefs := DefaultNotificationPreferences
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&prefs.ReportDigests)
+	if err != nil && err != sql.ErrNoRows {
+		return prefs, err
+	}
+
+	return prefs, nil
+}
+
+// Update() saves the user's preferences
+func (m NotificationModel) Update(userID int64, prefs NotificationPreferences) error {
+	query := `
+		INSERT INTO notification_preferences(user_id, report_digests)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET report_digests = EXCLUDED.report_digests, updated_at = NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, prefs.ReportDigests)
+
+	return err
+}
+
+// ReportDigestOptOuts() returns which of the emails belong to users who turned report
+// digests off, addresses without an account are never opted out
+func (m NotificationModel) ReportDigestOptOuts(emails []string) (map[string]bool, error) {
+	query := `
+		SELECT u.email
+		FROM users u
+		INNER JOIN notification_preferences np ON np.user_id = u.id
+		WHERE u.email = ANY($1) AND NOT np.report_digests
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(emails))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	optOuts := make(map[string]bool)
+
+	for rows.Next() {
+		var email string
+
+		err := rows.Scan(&email)
+		if err != nil {
+			return nil, err
+		}
+
+		optOuts[email] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return optOuts, nil
+}
@@ -0,0 +1,869 @@
+//Filename: internal/data/organizations.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+var (
+	ErrDuplicateOrganization = errors.New("an organization with this name already exists")
+	ErrAlreadyMember         = errors.New("user already belongs to an organization")
+	ErrLastBroker            = errors.New("organization must keep at least one broker")
+	ErrListingsNotReassigned = errors.New("member still has listings assigned")
+	ErrListingOwned          = errors.New("listing is owned by another organization")
+	ErrNotMember             = errors.New("user is not a member of the organization")
+	ErrListingAgentNotMember = errors.New("listing's agent is not a member of the organization")
+)
+
+// the roles a member can hold, brokers and admins manage the agency
+const (
+	OrgRoleBroker = "broker"
+	OrgRoleAgent  = "agent"
+	OrgRoleAdmin  = "admin"
+)
+
+var OrganizationRoles = []string{OrgRoleBroker, OrgRoleAgent, OrgRoleAdmin}
+
+// Organization is a brokerage/agency that owns listings and employs agents
+type Organization struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Email       string    `json:"email"`
+	Phone       string    `json:"phone"`
+	Address     string    `json:"address"`
+	Website     string    `json:"website"`
+	Description string    `json:"description"`
+	DistrictId  *int64    `json:"district_id,omitempty"`
+	Version     int32     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OrganizationMember is a user working for an organization
+type OrganizationMember struct {
+	OrganizationID int64     `json:"organization_id"`
+	UserID         int64     `json:"user_id"`
+	Username       string    `json:"username"`
+	Fullname       string    `json:"fullname"`
+	Email          string    `json:"email"`
+	Phone          string    `json:"phone"`
+	Role           string    `json:"role"`
+	Listings       int64     `json:"listings"`
+	JoinedAt       time.Time `json:"joined_at"`
+}
+
+// OrganizationInvitation is an offer to join an organization, it waits for the user to accept it
+type OrganizationInvitation struct {
+	OrganizationID   int64     `json:"organization_id"`
+	OrganizationName string    `json:"organization_name"`
+	UserID           int64     `json:"-"`
+	Email            string    `json:"-"`
+	Role             string    `json:"role"`
+	Expiry           time.Time `json:"expiry"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// CanManage reports if the member may edit the organization and its members
+func (m *OrganizationMember) CanManage() bool {
+	return m.Role == OrgRoleBroker || m.Role == OrgRoleAdmin
+}
+
+// OrganizationStats are the headline numbers of the agency profile
+type OrganizationStats struct {
+	ActiveListings int64   `json:"active_listings"`
+	SoldListings   int64   `json:"sold_listings"`
+	LeasedListings int64   `json:"leased_listings"`
+	TotalSales     float64 `json:"total_sales"`
+}
+
+var slugRX = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns an organization name into the url friendly form used in its profile
+func Slugify(name string) string {
+	return strings.Trim(slugRX.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(org.Slug != "", "name", "must contain letters or numbers")
+
+	if org.Email != "" {
+		ValidateEmail(v, org.Email)
+	}
+
+	v.Check(len(org.Phone) <= 20, "phone", "must not be more than 20 bytes long")
+	v.Check(len(org.Website) <= 500, "website", "must not be more than 500 bytes long")
+	v.Check(len(org.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+
+	if org.DistrictId != nil {
+		v.Check(*org.DistrictId > 0, "district_id", "must be a valid district")
+	}
+}
+
+func ValidateOrganizationRole(v *validator.Validator, role string) {
+	v.Check(validator.In(role, OrganizationRoles...), "role", "must be broker, agent or admin")
+}
+
+// Define a OrganizationModel which wrap a sql.DB connection pool
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// Insert() creates the organization with the creating user as its broker
+func (m OrganizationModel) Insert(org *Organization, brokerID int64) error {
+
+	query := `
+		INSERT INTO organizations(name, slug, email, phone, address, website, description, districtId)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, version, created_at
+	`
+
+	args := []interface{}{
+		org.Name,
+		org.Slug,
+		org.Email,
+		org.Phone,
+		org.Address,
+		org.Website,
+		org.Description,
+		org.DistrictId,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&org.ID, &org.Version, &org.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organizations_slug_key"`:
+			return ErrDuplicateOrganization
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO organization_members(organization_id, user_id, role)
+		VALUES($1, $2, $3)
+	`, org.ID, brokerID, OrgRoleBroker)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organization_members_user_id_key"`:
+			return ErrAlreadyMember
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get() returns an organization by id
+func (m OrganizationModel) Get(id int64) (*Organization, error) {
+
+	//Ensure that there is a valid id
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, name, slug, email, phone, address, website, description, districtId, version, created_at
+		FROM organizations
+		WHERE id = $1
+	`
+
+	var org Organization
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&org.ID,
+		&org.Name,
+		&org.Slug,
+		&org.Email,
+		&org.Phone,
+		&org.Address,
+		&org.Website,
+		&org.Description,
+		&org.DistrictId,
+		&org.Version,
+		&org.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &org, nil
+}
+
+// GetAll() returns a page of organizations, optionally searched by name
+func (m OrganizationModel) GetAll(name string, filters Filters) ([]*Organization, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, name, slug, email, phone, address, website, description, districtId, version, created_at
+		FROM organizations
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortOrder())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	orgs := []*Organization{}
+
+	for rows.Next() {
+		var org Organization
+		err := rows.Scan(
+			&totalRecords,
+			&org.ID,
+			&org.Name,
+			&org.Slug,
+			&org.Email,
+			&org.Phone,
+			&org.Address,
+			&org.Website,
+			&org.Description,
+			&org.DistrictId,
+			&org.Version,
+			&org.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		orgs = append(orgs, &org)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return orgs, metadata, nil
+}
+
+// Update() saves the profile of an organization, the version check stops two
+// managers overwriting each other
+func (m OrganizationModel) Update(org *Organization) error {
+
+	query := `
+		UPDATE organizations
+		SET name = $1, slug = $2, email = $3, phone = $4, address = $5, website = $6, description = $7, districtId = $8,
+		version = version + 1
+		WHERE id = $9 AND version = $10
+		RETURNING version
+	`
+
+	args := []interface{}{
+		org.Name,
+		org.Slug,
+		org.Email,
+		org.Phone,
+		org.Address,
+		org.Website,
+		org.Description,
+		org.DistrictId,
+		org.ID,
+		org.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "organizations_slug_key"`:
+			return ErrDuplicateOrganization
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetStats() returns the listing and sales totals of an organization
+func (m OrganizationModel) GetStats(id int64) (*OrganizationStats, error) {
+
+	query := `
+		SELECT
+		count(l.id) filter (where ps.name = 'Available'),
+		count(l.id) filter (where ps.name = 'Sold'),
+		count(l.id) filter (where ps.name = 'Leased'),
+		COALESCE(sum(c.sale_price) filter (where c.lease_term_months IS NULL), 0)
+		FROM listing l inner join propertystatus ps on l.propertystatusid = ps.id
+		left join closings c on c.listing_id = l.id
+		WHERE l.organization_id = $1
+	`
+
+	var stats OrganizationStats
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&stats.ActiveListings,
+		&stats.SoldListings,
+		&stats.LeasedListings,
+		&stats.TotalSales,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// the member columns and the count of the organization's listings assigned to them
+const memberColumns = `
+	om.organization_id, u.id, u.username, u.fullname, u.email, u.phone, om.role,
+	(select count(*) from userproperties up inner join listing l on l.id = up.listingid
+		where up.userid = u.id and l.organization_id = om.organization_id),
+	om.joined_at
+`
+
+func scanMember(row interface{ Scan(...interface{}) error }) (*OrganizationMember, error) {
+
+	var member OrganizationMember
+
+	err := row.Scan(
+		&member.OrganizationID,
+		&member.UserID,
+		&member.Username,
+		&member.Fullname,
+		&member.Email,
+		&member.Phone,
+		&member.Role,
+		&member.Listings,
+		&member.JoinedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// GetMembers() returns the members of an organization, brokers first
+func (m OrganizationModel) GetMembers(orgID int64) ([]*OrganizationMember, error) {
+
+	query := `
+		SELECT ` + memberColumns + `
+		FROM organization_members om inner join users u on u.id = om.user_id
+		WHERE om.organization_id = $1
+		ORDER BY om.role = 'broker' DESC, u.fullname
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	members := []*OrganizationMember{}
+
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// GetMember() returns a user's membership of an organization
+func (m OrganizationModel) GetMember(orgID, userID int64) (*OrganizationMember, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getMember(ctx, m.DB, orgID, userID)
+}
+
+func getMember(ctx context.Context, q querier, orgID, userID int64) (*OrganizationMember, error) {
+
+	query := `
+		SELECT ` + memberColumns + `
+		FROM organization_members om inner join users u on u.id = om.user_id
+		WHERE om.organization_id = $1 AND om.user_id = $2
+	`
+
+	member, err := scanMember(q.QueryRowContext(ctx, query, orgID, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return member, nil
+}
+
+// InviteMember() invites a user to an organization by username, inviting them again
+// renews the invitation with the new role
+func (m OrganizationModel) InviteMember(orgID int64, username, role string, ttl time.Duration) (*OrganizationInvitation, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var invitation OrganizationInvitation
+	var member bool
+
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT id, email, EXISTS (select 1 from organization_members where user_id = users.id)
+		FROM users
+		WHERE username = $1
+	`, username).Scan(&invitation.UserID, &invitation.Email, &member)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if member {
+		return nil, ErrAlreadyMember
+	}
+
+	query := `
+		INSERT INTO organization_invitations(organization_id, user_id, role, expiry)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id, user_id)
+		DO UPDATE SET role = EXCLUDED.role, expiry = EXCLUDED.expiry, created_at = NOW()
+		RETURNING organization_id, (select name from organizations where id = $1), role, expiry, created_at
+	`
+
+	err = m.DB.QueryRowContext(ctx, query, orgID, invitation.UserID, role, time.Now().Add(ttl)).Scan(
+		&invitation.OrganizationID,
+		&invitation.OrganizationName,
+		&invitation.Role,
+		&invitation.Expiry,
+		&invitation.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// GetInvitationsForUser() returns the invitations a user has not answered yet
+func (m OrganizationModel) GetInvitationsForUser(userID int64) ([]*OrganizationInvitation, error) {
+
+	query := `
+		SELECT i.organization_id, o.name, i.user_id, i.role, i.expiry, i.created_at
+		FROM organization_invitations i inner join organizations o on o.id = i.organization_id
+		WHERE i.user_id = $1 AND i.expiry > NOW()
+		ORDER BY i.created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	invitations := []*OrganizationInvitation{}
+
+	for rows.Next() {
+		var invitation OrganizationInvitation
+
+		err := rows.Scan(
+			&invitation.OrganizationID,
+			&invitation.OrganizationName,
+			&invitation.UserID,
+			&invitation.Role,
+			&invitation.Expiry,
+			&invitation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// AcceptInvitation() makes the user a member of the organization that invited them,
+// their other invitations are dropped since a user works for one agency at a time
+func (m OrganizationModel) AcceptInvitation(orgID, userID int64) (*OrganizationMember, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var role string
+	var live bool
+
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM organization_invitations
+		WHERE organization_id = $1 AND user_id = $2
+		RETURNING role, expiry > NOW()
+	`, orgID, userID).Scan(&role, &live)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if !live {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO organization_members(organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+	`, orgID, userID, role)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organization_members_user_id_key"`:
+			return nil, ErrAlreadyMember
+		default:
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM organization_invitations WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := getMember(ctx, tx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return member, tx.Commit()
+}
+
+// DeclineInvitation() removes an invitation without joining the organization
+func (m OrganizationModel) DeclineInvitation(orgID, userID int64) error {
+
+	query := `
+		DELETE FROM organization_invitations
+		WHERE organization_id = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, orgID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// lockOrganization holds the organization row so membership changes are made one at a time
+func lockOrganization(ctx context.Context, tx *sql.Tx, orgID int64) error {
+
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, orgID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// otherBrokers counts the brokers of the organization other than the user
+func otherBrokers(ctx context.Context, tx *sql.Tx, orgID, userID int64) (int, error) {
+
+	query := `
+		SELECT count(*) FROM organization_members
+		WHERE organization_id = $1 AND user_id <> $2 AND role = 'broker'
+	`
+
+	var count int
+
+	err := tx.QueryRowContext(ctx, query, orgID, userID).Scan(&count)
+
+	return count, err
+}
+
+// UpdateMemberRole() changes the role of a member, the last broker cannot be demoted
+func (m OrganizationModel) UpdateMemberRole(member *OrganizationMember, role string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockOrganization(ctx, tx, member.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	if member.Role == OrgRoleBroker && role != OrgRoleBroker {
+		brokers, err := otherBrokers(ctx, tx, member.OrganizationID, member.UserID)
+		if err != nil {
+			return err
+		}
+		if brokers == 0 {
+			return ErrLastBroker
+		}
+	}
+
+	query := `
+		UPDATE organization_members SET role = $1
+		WHERE organization_id = $2 AND user_id = $3
+	`
+
+	result, err := tx.ExecContext(ctx, query, role, member.OrganizationID, member.UserID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	member.Role = role
+
+	return tx.Commit()
+}
+
+// RemoveMember() takes a member out of the organization. Any of the organization's
+// listings assigned to them are handed to reassignTo, which must be another member;
+// it may be zero only when they have no listings. The number of listings moved is returned
+func (m OrganizationModel) RemoveMember(orgID, userID, reassignTo int64) (int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockOrganization(ctx, tx, orgID)
+	if err != nil {
+		return 0, err
+	}
+
+	member, err := getMember(ctx, tx, orgID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if member.Role == OrgRoleBroker {
+		brokers, err := otherBrokers(ctx, tx, orgID, userID)
+		if err != nil {
+			return 0, err
+		}
+		if brokers == 0 {
+			return 0, ErrLastBroker
+		}
+	}
+
+	if member.Listings > 0 {
+		if reassignTo == 0 || reassignTo == userID {
+			return 0, ErrListingsNotReassigned
+		}
+
+		//the new agent has to work for the same agency
+		_, err = getMember(ctx, tx, orgID, reassignTo)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRecordNotFound):
+				return 0, ErrNotMember
+			default:
+				return 0, err
+			}
+		}
+	}
+
+	query := `
+		UPDATE userproperties SET userid = $1
+		WHERE userid = $2 AND listingid IN (select id from listing where organization_id = $3)
+	`
+
+	result, err := tx.ExecContext(ctx, query, reassignTo, userID, orgID)
+	if err != nil {
+		return 0, err
+	}
+
+	reassigned, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`, orgID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return reassigned, tx.Commit()
+}
+
+// AssignListing() makes the organization the owner of a listing whose agent already
+// works for it and hands the listing to agentID, another member. Agents who are not
+// members keep their hold on the listing, so an agency cannot take over a listing
+// of an independent agent
+func (m OrganizationModel) AssignListing(orgID, listingID, agentID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = getMember(ctx, tx, orgID, agentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return ErrNotMember
+		default:
+			return err
+		}
+	}
+
+	var owner sql.NullInt64
+
+	err = tx.QueryRowContext(ctx, `SELECT organization_id FROM listing WHERE id = $1 FOR UPDATE`, listingID).Scan(&owner)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if owner.Valid && owner.Int64 != orgID {
+		return ErrListingOwned
+	}
+
+	//the agent the listing is with now has to be one of ours
+	var currentAgent int64
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT up.userid FROM userproperties up
+		inner join organization_members om on om.user_id = up.userid
+		WHERE up.listingid = $1 AND om.organization_id = $2
+		ORDER BY up.userid = $3 DESC
+		LIMIT 1
+	`, listingID, orgID, agentID).Scan(&currentAgent)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrListingAgentNotMember
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE listing SET organization_id = $1 WHERE id = $2`, orgID, listingID)
+	if err != nil {
+		return err
+	}
+
+	if currentAgent != agentID {
+		_, err = tx.ExecContext(ctx, `UPDATE userproperties SET userid = $1 WHERE listingid = $2 AND userid = $3`, agentID, listingID, currentAgent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
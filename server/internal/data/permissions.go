@@ -28,6 +28,7 @@ type PermissionsModel struct {
 	DB *sql.DB
 }
 
+// GetAllForUser() returns the permissions granted to the user directly and through their roles
 func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 		SELECT p.code FROM 
@@ -35,21 +36,56 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 		INNER JOIN
 		users_permissions up
 		ON up.permission_id = p.id
-		INNER JOIN users u
-		ON up.user_id = u.id
-		WHERE u.id = $1
+		WHERE up.user_id = $1
+		UNION
+		SELECT p.code FROM
+		permissions p
+		INNER JOIN roles_permissions rp
+		ON rp.permission_id = p.id
+		INNER JOIN users_roles ur
+		ON ur.role_id = rp.role_id
+		WHERE ur.user_id = $1
+		ORDER BY 1
 	`
 
+	return m.queryCodes(query, userID)
+}
+
+// GetDirectForUser() returns only the permissions granted to the user directly
+func (m PermissionsModel) GetDirectForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT p.code FROM
+		permissions p
+		INNER JOIN users_permissions up
+		ON up.permission_id = p.id
+		WHERE up.user_id = $1
+		ORDER BY 1
+	`
+
+	return m.queryCodes(query, userID)
+}
+
+// GetAll() returns every permission code
+func (m PermissionsModel) GetAll() (Permissions, error) {
+	query := `
+		SELECT code FROM permissions ORDER BY code
+	`
+
+	return m.queryCodes(query)
+}
+
+func (m PermissionsModel) queryCodes(query string, args ...interface{}) (Permissions, error) {
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
-	var permissions Permissions
+	permissions := Permissions{}
 	for rows.Next() {
 		var permission string
 		err := rows.Scan(&permission)
@@ -74,7 +110,7 @@ func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
 
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
-		
+		ON CONFLICT DO NOTHING
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -83,3 +119,31 @@ func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
 
 	return err
 }
+
+// RemoveForUser() revokes a permission granted to the user directly, permissions
+// that come from a role stay until the role is removed
+func (m PermissionsModel) RemoveForUser(userID int64, code string) error {
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1 AND permission_id = (select id from permissions where code = $2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, code)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+// Filename: internal/data/profile.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Profile is everything the signed in user sees about their own account
+type Profile struct {
+	ID            int64                   `json:"id"`
+	Username      string                  `json:"username"`
+	Fullname      string                  `json:"fullname"`
+	Email         string                  `json:"email"`
+	PendingEmail  *string                 `json:"pending_email"`
+	Phone         string                  `json:"phone"`
+	Address       string                  `json:"address"`
+	DistrictID    int64                   `json:"district_id"`
+	District      string                  `json:"district"`
+	UserTypeID    int64                   `json:"user_type_id"`
+	UserType      string                  `json:"user_type"`
+	Activated     bool                    `json:"activated"`
+	ProfileImage  *string                 `json:"profile_image"`
+	CreatedAt     time.Time               `json:"created_at"`
+	Roles         []string                `json:"roles"`
+	Permissions   Permissions             `json:"permissions"`
+	Organization  *ProfileOrganization    `json:"organization"`
+	Notifications NotificationPreferences `json:"notifications"`
+}
+
+// ProfileOrganization is the agency the user works for
+type ProfileOrganization struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// GetProfile() returns the user's account with their profile image and agency, the
+// roles, permissions and notifications are filled in by the caller
+func (m UserModel) GetProfile(id int64) (*Profile, error) {
+	query := `
+		SELECT u.id, u.username, u.fullname, u.email, u.pending_email, u.phone, u.address,
+			u.districtid, d.name, u.usertypeid, ut.name, u.activated, img.image_url, u.created_at,
+			o.id, o.name, om.role
+		FROM users u
+		INNER JOIN district d ON d.id = u.districtid
+		INNER JOIN usertype ut ON ut.id = u.usertypeid
+		LEFT JOIN LATERAL (
+			SELECT image_url FROM userprofileimage WHERE user_id = u.id LIMIT 1
+		) img ON true
+		LEFT JOIN organization_members om ON om.user_id = u.id
+		LEFT JOIN organizations o ON o.id = om.organization_id
+		WHERE u.id = $1 AND u.closed_at IS NULL
+	`
+
+	var profile Profile
+	var orgID *int64
+	var orgName, orgRole *string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&profile.ID,
+		&profile.Username,
+		&profile.Fullname,
+		&profile.Email,
+		&profile.PendingEmail,
+		&profile.Phone,
+		&profile.Address,
+		&profile.DistrictID,
+		&profile.District,
+		&profile.UserTypeID,
+		&profile.UserType,
+		&profile.Activated,
+		&profile.ProfileImage,
+		&profile.CreatedAt,
+		&orgID,
+		&orgName,
+		&orgRole,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if orgID != nil {
+		profile.Organization = &ProfileOrganization{ID: *orgID, Name: *orgName, Role: *orgRole}
+	}
+
+	return &profile, nil
+}
+
+// Close() shuts the user's account. The row stays so listings, closings and bookings
+// keep their agent and guest, but the account is deactivated, its password is replaced
+// with one nobody knows and every way of signing in to it is removed
+func (m UserModel) Close(userID int64) error {
+
+	var p password
+	err := p.Set(randomSecret())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET activated = false, password_hash = $2, pending_email = NULL, closed_at = NOW()
+		WHERE id = $1 AND closed_at IS NULL
+	`, userID, p.hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = removeSignIns(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Erase() replaces the user's personal data. The row stays, with the same id, so
+// listings, closings, bookings and agency stats still add up, but nothing in it or
+// around it points at the person any more. It returns the uploaded files to remove
+func (m UserModel) Erase(userID int64) ([]string, error) {
+
+	var p password
+	err := p.Set(randomSecret())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var username string
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT username FROM users WHERE id = $1 AND erased_at IS NULL FOR UPDATE
+	`, userID).Scan(&username)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET username = 'deleted-' || id, fullname = 'Deleted User', email = 'deleted-' || id || '@erased.invalid',
+			phone = '0', address = '', pending_email = NULL, password_hash = $2, activated = false,
+			closed_at = COALESCE(closed_at, NOW()), erased_at = NOW()
+		WHERE id = $1
+	`, userID, p.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = removeSignIns(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM notification_preferences WHERE user_id = $1`,
+		`DELETE FROM oidc_states WHERE user_id = $1`,
+		`DELETE FROM organization_invitations WHERE user_id = $1`,
+		`UPDATE bookings SET message = '' WHERE guest_id = $1`,
+		`UPDATE audit_log SET ip = '', details = '{}' WHERE user_id = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, "user:"+strings.ToLower(username))
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := deletePaths(ctx, tx, `DELETE FROM userprofileimage WHERE user_id = $1 RETURNING image_url`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exports, err := deletePaths(ctx, tx, `DELETE FROM data_exports WHERE user_id = $1 RETURNING path`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(images, exports...), nil
+}
+
+// removeSignIns deletes every way of signing in to the user's account
+func removeSignIns(ctx context.Context, tx *sql.Tx, userID int64) error {
+
+	for _, query := range []string{
+		`DELETE FROM tokens WHERE user_id = $1`,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM user_mfa WHERE user_id = $1`,
+		`DELETE FROM mfa_recovery_codes WHERE user_id = $1`,
+	} {
+		_, err := tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+// Filename: internal/data/refreshtokens.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// TokenPair is what a client gets when it signs in or refreshes
+type TokenPair struct {
+	Access  *Token `json:"authentication_token"`
+	Refresh *Token `json:"refresh_token"`
+}
+
+// TokenTTL holds how long the tokens of a pair live. With StatelessAccess only the
+// refresh token is made, the caller signs its own access token
+type TokenTTL struct {
+	Access          time.Duration
+	Refresh         time.Duration
+	StatelessAccess bool
+}
+
+// newPair generates the access and refresh token of a family
+func newPair(userID int64, ttl TokenTTL, family []byte, userAgent, ip string) (*TokenPair, error) {
+
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	generate := func(ttl time.Duration, scope string) (*Token, error) {
+		token, err := generateTokenT(userID, ttl, scope)
+		if err != nil {
+			return nil, err
+		}
+		token.Family = family
+		token.UserAgent = userAgent
+		token.IP = ip
+		return token, nil
+	}
+
+	var (
+		pair = &TokenPair{}
+		err  error
+	)
+
+	pair.Refresh, err = generate(ttl.Refresh, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ttl.StatelessAccess {
+		pair.Access, err = generate(ttl.Access, ScopeAuthentication)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pair, nil
+}
+
+// insertPair stores both tokens of a pair inside tx
+func insertPair(ctx context.Context, tx *sql.Tx, pair *TokenPair) error {
+
+	query := `
+		INSERT INTO tokens(hash, user_id, expiry, scope, user_agent, ip, family)
+		VALUES($1,$2,$3,$4,$5,$6,$7)
+	`
+
+	for _, token := range []*Token{pair.Access, pair.Refresh} {
+		if token == nil {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP, token.Family)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewPair() signs a user in on a device, starting a new token family
+func (m TokenModel) NewPair(userID int64, ttl TokenTTL, userAgent, ip string) (*TokenPair, error) {
+
+	family := make([]byte, 16)
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := newPair(userID, ttl, family, userAgent, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = insertPair(ctx, tx, pair)
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, tx.Commit()
+}
+
+// Rotate() swaps a refresh token for a new pair in the same family. A refresh token
+// can only be used once, presenting one that was already rotated means it was
+// copied so the whole family is revoked and ErrRefreshTokenReused is returned
+func (m TokenModel) Rotate(tokenPlainText string, ttl TokenTTL, userAgent, ip string) (*TokenPair, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		userID    int64
+		family    []byte
+		rotatedAt sql.NullTime
+	)
+
+	//lock the token so two refreshes with it cannot both succeed
+	query := `
+		SELECT user_id, family, rotated_at FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+		FOR UPDATE
+	`
+
+	err = tx.QueryRowContext(ctx, query, TokenHash(tokenPlainText), ScopeRefresh).Scan(&userID, &family, &rotatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidRefreshToken
+		default:
+			return nil, err
+		}
+	}
+
+	if rotatedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, family)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET rotated_at = NOW() WHERE hash = $1`, TokenHash(tokenPlainText))
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := newPair(userID, ttl, family, userAgent, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertPair(ctx, tx, pair)
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, tx.Commit()
+}
@@ -7,21 +7,75 @@ import (
 	"database/sql"
 	"fmt"
 	"time"
+
+	"realestatebelize.imerlopez.net/internal/validator"
 )
 
 type TopAgents struct {
+	AgentID           int64   `json:"agent_id"`
 	AgentName         string  `json:"agent_name"`
+	ProfileImage      string  `json:"profile_image"`
 	TotalPropertySold int64   `json:"total_property_sold"`
 	TotalSales        float64 `json:"total_sales"`
+	AvgDaysOnMarket   float64 `json:"avg_days_on_market"`
+}
+
+// TopAgentsFilters controls how the leaderboard is ranked and how many agents it returns
+type TopAgentsFilters struct {
+	ReportFilters
+	RankBy     string
+	RankByList []string
+	Limit      int
+}
+
+func ValidateTopAgentsFilters(v *validator.Validator, f TopAgentsFilters) {
+	ValidateReportFilters(v, f.ReportFilters)
+	v.Check(f.GroupBy == "", "group_by", "is not supported by this report")
+
+	v.Check(f.Limit > 0, "limit", "must be greater than zero")
+	v.Check(f.Limit <= 100, "limit", "must be a maximum of 100")
+
+	//check that the rank_by params matches a value in the acceptable list
+	v.Check(validator.In(f.RankBy, f.RankByList...), "rank_by", "invalid rank_by value")
+}
+
+// The rankOrder() method safely maps the rank_by parameter to an ORDER BY expression
+func (f TopAgentsFilters) rankOrder() string {
+	switch f.RankBy {
+	case "units":
+		return "count(c.id) DESC, sum(c.sale_price) DESC"
+	case "volume":
+		return "sum(c.sale_price) DESC, count(c.id) DESC"
+	case "days_on_market":
+		//fewer days on the market ranks higher
+		return "avg(c.close_date - COALESCE(l.listed_at, l.created_at)::date) ASC, count(c.id) DESC"
+	}
+	panic("unsafe rank_by parameter: " + f.RankBy)
 }
 
 type ListingsStatus struct {
-	SoldLeased int64 `json:"sold_leased"`
-	Available  int64 `json:"available"`
+	Group      string `json:"group,omitempty"`
+	SoldLeased int64  `json:"sold_leased"`
+	Available  int64  `json:"available"`
 }
 
 type TotalSales struct {
-	TotalSales float64 `json:"total_sales"`
+	Group           string  `json:"group,omitempty"`
+	TotalSales      float64 `json:"total_sales"`
+	TotalListPrice  float64 `json:"total_list_price"`
+	TotalClosed     int64   `json:"total_closed"`
+	ListToSaleRatio float64 `json:"list_to_sale_ratio"`
+}
+
+// InventoryAging shows how long the listings still on the market have been sitting
+type InventoryAging struct {
+	District           string  `json:"district"`
+	PropertyType       string  `json:"property_type"`
+	ActiveListings     int64   `json:"active_listings"`
+	MedianDaysOnMarket float64 `json:"median_days_on_market"`
+	Days0To30          int64   `json:"days_0_30"`
+	Days31To90         int64   `json:"days_31_90"`
+	Days90Plus         int64   `json:"days_90_plus"`
 }
 
 // Define a ReportModel which wrap a sql.DB connection pool
@@ -29,24 +83,37 @@ type ReportModel struct {
 	DB *sql.DB
 }
 
-func (m ReportModel) GetTopAgents() ([]*TopAgents, error) {
+// Get the agents with the best sales that closed within the range
+func (m ReportModel) GetTopAgents(filters TopAgentsFilters) ([]*TopAgents, error) {
 	//construct query
 
 	query := fmt.Sprintf(`
-	select  u.fullname, count(l.id), sum(l.price) from users u inner join userproperties up on u.id = up.userid
+	select u.id, u.fullname, COALESCE(img.image_url, ''), count(c.id), sum(c.sale_price),
+	avg(c.close_date - COALESCE(l.listed_at, l.created_at)::date)::float8
+	from users u inner join userproperties up on u.id = up.userid
 	inner join listing l on l.id = up.listingid
-	inner join propertystatus ps on ps.id = l.propertystatusid
-	where ps.name='Sold'  group by u.fullname, l.price 
-	order by l.price desc 
-	limit 5
-		`)
+	inner join closings c on c.listing_id = l.id
+	left join lateral (
+		select image_url from userprofileimage
+		where user_id = u.id
+		order by image_url
+		limit 1
+	) img on true
+	where c.lease_term_months is null
+	AND (c.close_date >= $1::date OR $1::date IS NULL)
+	AND (c.close_date <= $2::date OR $2::date IS NULL)
+	AND (l.organization_id = $4::bigint OR $4::bigint IS NULL)
+	group by u.id, u.fullname, img.image_url
+	order by %s, u.id ASC
+	limit $3
+		`, filters.rankOrder())
 	//CREATE a 3 sec timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
 	//execute
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, filters.from(), filters.to(), filters.Limit, filters.organization())
 
 	if err != nil {
 		return nil, err
@@ -64,9 +131,12 @@ func (m ReportModel) GetTopAgents() ([]*TopAgents, error) {
 		var topagent TopAgents
 		//scan the values from row into  topagent struct
 		err := rows.Scan(
+			&topagent.AgentID,
 			&topagent.AgentName,
+			&topagent.ProfileImage,
 			&topagent.TotalPropertySold,
 			&topagent.TotalSales,
+			&topagent.AvgDaysOnMarket,
 		)
 
 		if err != nil {
@@ -89,21 +159,35 @@ func (m ReportModel) GetTopAgents() ([]*TopAgents, error) {
 
 }
 
-func (m ReportModel) GetListingStatus() ([]*ListingsStatus, error) {
+// Get the Available vs Sold/Leased counts of listings created within the range, a listing
+// with several agents is counted once, under the agent assigned first
+func (m ReportModel) GetListingStatus(filters ReportFilters) ([]*ListingsStatus, error) {
 	//construct query
 
 	query := fmt.Sprintf(`
-	select (select count(lg.id) from listing lg inner join propertystatus ps on lg.propertystatusid=ps.id where ps.name='Sold' or ps.Name='Leased') as SoldLeased,  
-	(select count(lg.id) from listing lg inner join propertystatus ps on lg.propertystatusid=ps.id where ps.name='Available') as Available
-
-		`)
+	select %s as grp,
+	count(l.id) filter (where ps.name='Sold' or ps.name='Leased') as SoldLeased,
+	count(l.id) filter (where ps.name='Available') as Available
+	from listing l inner join propertystatus ps on l.propertystatusid=ps.id
+	inner join district d on l.districtid = d.id
+	inner join propertytype pt on l.propertytypeid = pt.id
+	left join lateral (
+		select userid from userproperties where listingid = l.id order by userid limit 1
+	) up on true
+	left join users u on u.id = up.userid
+	left join organizations o on o.id = l.organization_id
+	where (l.created_at::date >= $1::date OR $1::date IS NULL)
+	AND (l.created_at::date <= $2::date OR $2::date IS NULL)
+	AND (l.organization_id = $3::bigint OR $3::bigint IS NULL)
+	%s
+		`, filters.groupColumn("l.created_at"), filters.groupClause())
 	//CREATE a 3 sec timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
 	//execute
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, filters.from(), filters.to(), filters.organization())
 
 	if err != nil {
 		return nil, err
@@ -121,6 +205,7 @@ func (m ReportModel) GetListingStatus() ([]*ListingsStatus, error) {
 		var listing ListingsStatus
 		//scan the values from row into  topagent struct
 		err := rows.Scan(
+			&listing.Group,
 			&listing.SoldLeased,
 			&listing.Available,
 		)
@@ -145,22 +230,33 @@ func (m ReportModel) GetListingStatus() ([]*ListingsStatus, error) {
 
 }
 
-// Get Total Sales of all properties Sold/leased
-func (m ReportModel) GetTotalSales() ([]*TotalSales, error) {
+// Get Total Sales of all properties Sold/leased that closed within the range, each closing
+// is summed once even when the listing has several agents
+func (m ReportModel) GetTotalSales(filters ReportFilters) ([]*TotalSales, error) {
 	//construct query
 
 	query := fmt.Sprintf(`
-	SELECT sum(l.price) as totalSales from listing l inner join propertystatus ps on l.propertystatusid=ps.id 
-	where ps.name ='Sold' OR ps.name='Leased'
-
-		`)
+	SELECT %s as grp, COALESCE(sum(c.sale_price), 0) as totalSales, COALESCE(sum(l.price), 0) as totalListPrice, count(c.id) as totalClosed
+	from closings c inner join listing l on l.id = c.listing_id
+	inner join district d on l.districtid = d.id
+	inner join propertytype pt on l.propertytypeid = pt.id
+	left join lateral (
+		select userid from userproperties where listingid = l.id order by userid limit 1
+	) up on true
+	left join users u on u.id = up.userid
+	left join organizations o on o.id = l.organization_id
+	where (c.close_date >= $1::date OR $1::date IS NULL)
+	AND (c.close_date <= $2::date OR $2::date IS NULL)
+	AND (l.organization_id = $3::bigint OR $3::bigint IS NULL)
+	%s
+		`, filters.groupColumn("c.close_date"), filters.groupClause())
 	//CREATE a 3 sec timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	defer cancel()
 
 	//execute
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, filters.from(), filters.to(), filters.organization())
 
 	if err != nil {
 		return nil, err
@@ -178,13 +274,21 @@ func (m ReportModel) GetTotalSales() ([]*TotalSales, error) {
 		var totalsale TotalSales
 		//scan the values from row into  topagent struct
 		err := rows.Scan(
+			&totalsale.Group,
 			&totalsale.TotalSales,
+			&totalsale.TotalListPrice,
+			&totalsale.TotalClosed,
 		)
 
 		if err != nil {
 			return nil, err
 		}
 
+		//how much of the asking price was achieved on closing
+		if totalsale.TotalListPrice > 0 {
+			totalsale.ListToSaleRatio = totalsale.TotalSales / totalsale.TotalListPrice
+		}
+
 		//add the topagent to our slice
 		totalsales = append(totalsales, &totalsale)
 
@@ -200,3 +304,72 @@ func (m ReportModel) GetTotalSales() ([]*TotalSales, error) {
 	return totalsales, nil
 
 }
+
+// Get the median days on market and aging buckets of the active listings by district and type,
+// aging is measured to today so only the organization filter applies
+func (m ReportModel) GetInventoryAging(filters ReportFilters) ([]*InventoryAging, error) {
+	//construct query
+
+	query := `
+	select d.name, pt.name, count(l.id),
+	percentile_cont(0.5) within group (order by (NOW()::date - l.listed_at::date)),
+	count(l.id) filter (where NOW()::date - l.listed_at::date <= 30),
+	count(l.id) filter (where NOW()::date - l.listed_at::date between 31 and 90),
+	count(l.id) filter (where NOW()::date - l.listed_at::date > 90)
+	from listing l inner join district d on l.districtid = d.id
+	inner join propertytype pt on l.propertytypeid = pt.id
+	where l.listed_at IS NOT NULL AND l.off_market_at IS NULL
+	AND (l.organization_id = $1::bigint OR $1::bigint IS NULL)
+	group by d.name, pt.name
+	order by d.name, pt.name
+		`
+	//CREATE a 3 sec timeout context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	//execute
+	rows, err := m.DB.QueryContext(ctx, query, filters.organization())
+
+	if err != nil {
+		return nil, err
+	}
+
+	//close the result set
+	defer rows.Close()
+
+	//Initialize an empty slice to hold the aging data
+	inventory := []*InventoryAging{}
+
+	//iterate over the rows in the result set
+
+	for rows.Next() {
+		var aging InventoryAging
+		//scan the values from row into the aging struct
+		err := rows.Scan(
+			&aging.District,
+			&aging.PropertyType,
+			&aging.ActiveListings,
+			&aging.MedianDaysOnMarket,
+			&aging.Days0To30,
+			&aging.Days31To90,
+			&aging.Days90Plus,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		inventory = append(inventory, &aging)
+
+	}
+
+	//Check for errors after looping through the result set
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return inventory, nil
+
+}
@@ -0,0 +1,128 @@
+//Filename: internal/data/reportjobs.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ReportJob is a report emailed to managers on a cron schedule
+type ReportJob struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Report     string    `json:"report"`
+	Schedule   string    `json:"schedule"`
+	Recipients []string  `json:"recipients"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"-"`
+}
+
+// ReportRun records a single run of a report job
+type ReportRun struct {
+	ID         int64      `json:"id"`
+	JobID      int64      `json:"job_id"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Success    bool       `json:"success"`
+	Error      string     `json:"error"`
+}
+
+// Define a ReportJobModel which wrap a sql.DB connection pool
+type ReportJobModel struct {
+	DB *sql.DB
+}
+
+// GetAllEnabled() returns the jobs the scheduler should consider
+func (m ReportJobModel) GetAllEnabled() ([]*ReportJob, error) {
+
+	query := `
+		SELECT id, name, report, schedule, recipients, enabled, created_at
+		FROM report_jobs
+		WHERE enabled = true
+		ORDER BY id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	jobs := []*ReportJob{}
+
+	for rows.Next() {
+		var job ReportJob
+		err := rows.Scan(
+			&job.ID,
+			&job.Name,
+			&job.Report,
+			&job.Schedule,
+			pq.Array(&job.Recipients),
+			&job.Enabled,
+			&job.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// StartRun() records that a job has started
+func (m ReportJobModel) StartRun(jobID int64) (*ReportRun, error) {
+
+	query := `
+		INSERT INTO report_runs(job_id)
+		VALUES($1)
+		RETURNING id, started_at
+	`
+
+	run := &ReportRun{JobID: jobID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, jobID).Scan(&run.ID, &run.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// FinishRun() records the outcome of a run, a nil runErr means success
+func (m ReportJobModel) FinishRun(run *ReportRun, runErr error) error {
+
+	run.Success = runErr == nil
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+
+	query := `
+		UPDATE report_runs
+		SET finished_at = NOW(), success = $1, error = $2
+		WHERE id = $3
+		RETURNING finished_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, run.Success, run.Error, run.ID).Scan(&run.FinishedAt)
+}
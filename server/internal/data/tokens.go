@@ -10,6 +10,7 @@ import (
 	"encoding/base32"
 	"time"
 
+	"github.com/lib/pq"
 	"realestatebelize.imerlopez.net/internal/validator"
 )
 
@@ -18,6 +19,11 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeMFA            = "mfa"
+	ScopeUnlock         = "unlock"
+	ScopeEmailChange    = "email-change"
 )
 
 //Define token type
@@ -28,8 +34,25 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
+	IP        string    `json:"-"`
+	Family    []byte    `json:"-"`
 }
 
+// Session is a signed in device as the user sees it, one per refresh token family
+type Session struct {
+	ID         int64      `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"`
+}
+
+// the longest user agent we keep for a session
+const maxUserAgentLength = 512
+
 //generateToken() function returns a token
 
 func generateTokenT(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -53,13 +76,18 @@ func generateTokenT(userID int64, ttl time.Duration, scope string) (*Token, erro
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
 	//Hash the string token
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Hash = TokenHash(token.Plaintext)
 
 	return token, nil
 
 }
 
+// TokenHash returns the hash a plaintext token is stored under
+func TokenHash(tokenPlainText string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlainText))
+	return hash[:]
+}
+
 // check that the plaintext token is 26 bytes
 func ValidateTokenPlainText(v *validator.Validator, tokenPlainText string) {
 
@@ -90,8 +118,8 @@ func (m TokenModel) Insert(token *Token) error {
 
 	query := `
 
-		INSERT INTO tokens( hash, user_id, expiry, scope)
-		VALUES($1,$2,$3,$4)
+		INSERT INTO tokens( hash, user_id, expiry, scope, user_agent, ip, family)
+		VALUES($1,$2,$3,$4,$5,$6,$7)
 	
 	`
 
@@ -100,6 +128,9 @@ func (m TokenModel) Insert(token *Token) error {
 		token.UserID,
 		token.Expiry,
 		token.Scope,
+		token.UserAgent,
+		token.IP,
+		token.Family,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -110,6 +141,30 @@ func (m TokenModel) Insert(token *Token) error {
 
 }
 
+// CountRecent() returns how many tokens of the scope the user was sent in the window
+// and when the last one was sent
+func (m TokenModel) CountRecent(userID int64, scope string, window time.Duration) (int, *time.Time, error) {
+
+	query := `
+		SELECT count(*), max(created_at)
+		FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND created_at > NOW() - make_interval(secs => $3)
+	`
+
+	var count int
+	var last *time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, scope, window.Seconds()).Scan(&count, &last)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return count, last, nil
+}
+
 // Delete token
 func (m TokenModel) DeleteAllForUsers(scope string, userID int64) error {
 
@@ -124,3 +179,177 @@ func (m TokenModel) DeleteAllForUsers(scope string, userID int64) error {
 	return err
 
 }
+
+// DeleteAllForUser() removes every token of the user whatever the scope
+func (m TokenModel) DeleteAllForUser(userID int64) error {
+
+	query := `
+		DELETE FROM tokens WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// GetSessions() returns a row per signed in device of the user, that is the live
+// refresh token of each family. The session of the request is marked by the hash of
+// its token or, for signed tokens, by its family
+func (m TokenModel) GetSessions(userID int64, currentHash, currentFamily []byte) ([]*Session, error) {
+
+	query := `
+		SELECT r.id, r.user_agent, r.ip,
+		(select min(created_at) from tokens f where f.family = r.family),
+		(select max(last_used_at) from tokens f where f.family = r.family),
+		r.expiry,
+		(COALESCE(r.family = $4, false) OR EXISTS (select 1 from tokens f where f.family = r.family and f.hash = $2))
+		FROM tokens r
+		WHERE r.user_id = $1 AND r.scope = $3 AND r.rotated_at IS NULL AND r.expiry > NOW()
+		ORDER BY 5 DESC NULLS LAST, r.id DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, currentHash, ScopeRefresh, currentFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.Current,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession() signs a device out by revoking the family of the session's refresh token
+func (m TokenModel) DeleteSession(userID, sessionID int64) error {
+
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $2 AND family = (select family from tokens where id = $1 and user_id = $2 and scope = $3)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, sessionID, userID, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteFamily() revokes every token of a family
+func (m TokenModel) DeleteFamily(family []byte) error {
+
+	query := `
+		DELETE FROM tokens WHERE family = $1
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, family)
+	return err
+}
+
+// DeleteFamilyByHash() revokes the token stored under hash and every token of its family
+func (m TokenModel) DeleteFamilyByHash(hash []byte) error {
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 OR family = (select family from tokens where hash = $1)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hash)
+	return err
+}
+
+// DeleteSessionsForUser() signs the user out everywhere
+func (m TokenModel) DeleteSessionsForUser(userID int64) error {
+
+	query := `
+		DELETE FROM tokens WHERE user_id = $1 AND scope IN ($2, $3)
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh)
+	return err
+}
+
+// TouchLastUsed() saves a batch of last used times keyed by token hash in one
+// statement, times older than the stored one are ignored
+func (m TokenModel) TouchLastUsed(used map[string]time.Time) error {
+
+	if len(used) == 0 {
+		return nil
+	}
+
+	hashes := make([][]byte, 0, len(used))
+	times := make([]string, 0, len(used))
+	for hash, at := range used {
+		hashes = append(hashes, []byte(hash))
+		times = append(times, at.UTC().Format(time.RFC3339))
+	}
+
+	query := `
+		UPDATE tokens t SET last_used_at = u.used
+		FROM unnest($1::bytea[], $2::timestamptz[]) AS u(hash, used)
+		WHERE t.hash = u.hash AND (t.last_used_at IS NULL OR t.last_used_at < u.used)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(hashes), pq.Array(times))
+	return err
+}
+
+// DeleteByPlaintext() removes a single token
+func (m TokenModel) DeleteByPlaintext(scope, tokenPlainText string) error {
+
+	query := `
+		DELETE FROM tokens WHERE scope = $1 AND hash = $2
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, TokenHash(tokenPlainText))
+	return err
+}
@@ -36,14 +36,19 @@ type UserListingsModel struct {
 	DB *sql.DB
 }
 
-// assign user a property or listing
+// assign user a property or listing, assigning the same user again changes nothing
 func (m UserListingsModel) Insert(userlisting *UserListings) error {
 	//create our query
 	query :=
 		`	
-		INSERT INTO userproperties(userid, listingid)
-		VALUES((select id from users where username = $1), $2)
-		RETURNING listingid
+		WITH agent AS (
+			SELECT id FROM users WHERE username = $1
+		), assigned AS (
+			INSERT INTO userproperties(userid, listingid)
+			SELECT agent.id, $2 FROM agent
+			WHERE NOT EXISTS (select 1 from userproperties where userid = agent.id and listingid = $2)
+		)
+		SELECT $2::bigint FROM agent
 
 
 	`
@@ -59,7 +64,12 @@ func (m UserListingsModel) Insert(userlisting *UserListings) error {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&userlisting.ListingId)
 
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 	return nil
 }
@@ -113,3 +123,36 @@ func (m UserListingsModel) Get(id int64) (*ListingByUser, error) {
 	//Success
 	return &listing, nil
 }
+
+// CanManage() reports whether the user owns the listing or is a broker of the
+// agency the listing or its owning agent belongs to
+func (m UserListingsModel) CanManage(userID, listingID int64) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM userproperties up
+		WHERE up.listingid = $1 AND up.userid = $2
+	) OR EXISTS (
+		SELECT 1 FROM organization_members broker
+		WHERE broker.user_id = $2 AND broker.role = 'broker'
+		AND (
+			broker.organization_id = (select organization_id from listing where id = $1)
+			OR broker.organization_id IN (
+				select om.organization_id from organization_members om
+				inner join userproperties up on up.userid = om.user_id
+				where up.listingid = $1
+			)
+		)
+	)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var ok bool
+	err := m.DB.QueryRowContext(ctx, query, listingID, userID).Scan(&ok)
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
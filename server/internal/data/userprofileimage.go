@@ -153,3 +153,39 @@ func (m UserProfileImgModel) GetIdByUsername(username string) (int64, error) {
 
 	return userpimg.UserID, nil
 }
+
+// GetAllForUser() returns the paths of the user's uploaded profile images
+func (m UserProfileImgModel) GetAllForUser(userID int64) ([]string, error) {
+
+	query := `
+		SELECT image_url FROM userprofileimage WHERE user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []string{}
+
+	for rows.Next() {
+		var image string
+
+		err := rows.Scan(&image)
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
@@ -3,18 +3,20 @@ package data
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"sync"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 	"realestatebelize.imerlopez.net/internal/validator"
 )
 
 var (
-	ErrDuplicateEmail = errors.New("Duplicate email")
-	AnonymousUser     = &User{}
+	ErrDuplicateEmail    = errors.New("Duplicate email")
+	ErrDuplicateUsername = errors.New("Duplicate username")
+	AnonymousUser        = &User{}
 )
 
 type User struct {
@@ -30,6 +32,8 @@ type User struct {
 	UserTypeId int64     `json:"user_type_id"`
 	Activated  bool      `json:"activated"`
 	CreatedAt  time.Time `json:"created_at"`
+	// only read by GetByID, profile edits check it
+	Version int32 `json:"-"`
 }
 
 //UserListing struct use for get by id
@@ -47,12 +51,8 @@ type UserListing struct {
 	Activated    bool      `json:"activated"`
 	ProfileImage string    `json:"profile_image"`
 	CreatedAt    time.Time `json:"created_at"`
-}
-
-// User Struct for password reset
-type UserResetPassword struct {
-	Username string   `json:"username"`
-	Password password `json:"-"`
+	// only read by Get, UpdateUser checks it
+	Version int32 `json:"-"`
 }
 
 // create a customer password type
@@ -93,6 +93,22 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// a hash to check passwords against when the username is unknown, so the answer takes
+// as long as for a real user and does not give away which usernames exist
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// DummyPasswordCheck spends the time of a password check
+func DummyPasswordCheck(plaintextPassword string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), 12)
+	})
+
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(plaintextPassword))
+}
+
 //Validate the Client request
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -176,25 +192,6 @@ func ValidateUserListing(v *validator.Validator, user *UserListing) {
 
 }
 
-// Validate the userlisting struct
-func ValidateUserReset(v *validator.Validator, user *UserResetPassword) {
-	v.Check(user.Username != "", "username", "must be provided")
-	v.Check(len(user.Username) <= 200, "username", "must not be more than 200 bye Characters")
-
-	//validate Password
-	if user.Password.plaintext != nil {
-
-		ValidatePasswordPlaintext(v, *user.Password.plaintext)
-	}
-
-	//Ensure a hash of the password was created
-
-	if user.Password.hash == nil {
-		panic("Missing password hash for the user")
-	}
-
-}
-
 // create  user model
 type UserModel struct {
 	DB *sql.DB
@@ -228,12 +225,7 @@ func (m UserModel) Insert(user *User) error {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
 
 	if err != nil {
-		switch {
-		case err.Error() == `pq :duplicate key values violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		default:
-			return err
-		}
+		return userError(err)
 	}
 	return nil
 }
@@ -243,7 +235,7 @@ func (m UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
 		SET username = $1, password_hash = $2, fullname = $3, email = $4, phone = $5,
-		 address = $6, districtid = $7, usertypeid = $8 , activated = $9
+		 address = $6, districtid = $7, usertypeid = $8 , activated = $9, version = version + 1
 		WHERE id = $10
 		RETURNING id
 	`
@@ -263,83 +255,108 @@ func (m UserModel) Update(user *User) error {
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID)
 	if err != nil {
-		switch {
-		case err.Error() == `pq :duplicate key values violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		default:
-			return err
-		}
+		return userError(err)
 	}
 
 	return nil
 }
 
-// Update userListing - its different since every entries is string type so additional internal need to place for a successful update
-// The client can update their information
-func (m UserModel) UpdateUser(user *UserListing) error {
+// UpdateProfile() saves the profile a user edits themselves. The password, email and
+// activation are left alone so a password reset or an account close at the same time
+// is not undone, and an edit made since the user was read is an edit conflict
+func (m UserModel) UpdateProfile(user *User) error {
 	query := `
 		UPDATE users
-		SET username = $1, fullname = $2, email = $3, phone = $4,
-		 address = $5, districtid = (select id from district where name = $6), usertypeid = (select id from usertype where name = $7) , activated = $8
-		WHERE id = $9
-		RETURNING id
+		SET username = $1, fullname = $2, phone = $3, address = $4, districtid = $5, usertypeid = $6,
+		version = version + 1
+		WHERE id = $7 AND version = $8
+		RETURNING version
 	`
 	args := []interface{}{
 		user.Username,
 		user.Fullname,
-		user.Email,
 		user.Phone,
 		user.Address,
 		user.DistrictId,
 		user.UserTypeId,
-		user.Activated,
 		user.ID,
+		user.Version,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq :duplicate key values violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
-			return err
+			return userError(err)
 		}
 	}
 
 	return nil
 }
 
-// User - Reset password
-func (m UserModel) ResetPassword(user *UserResetPassword) error {
+// userError turns a unique violation on the email or username into ErrDuplicateEmail
+// or ErrDuplicateUsername
+func userError(err error) error {
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return err
+	}
+
+	switch pqErr.Constraint {
+	case "users_email_key":
+		return ErrDuplicateEmail
+	case "users_username_key":
+		return ErrDuplicateUsername
+	default:
+		return err
+	}
+}
+
+// Update userListing - its different since every entries is string type so additional internal need to place for a successful update
+// The client can update their information, the email only changes once a new one is confirmed
+// and an edit made since the user was read is an edit conflict
+func (m UserModel) UpdateUser(user *UserListing) error {
 	query := `
 		UPDATE users
-		SET password_hash = $1
-		WHERE username = $2
-		RETURNING username
+		SET username = $1, fullname = $2, phone = $3,
+		 address = $4, districtid = (select id from district where name = $5), usertypeid = (select id from usertype where name = $6) , activated = $7,
+		 version = version + 1
+		WHERE id = $8 AND version = $9
+		RETURNING version
 	`
 	args := []interface{}{
-
-		user.Password.hash,
 		user.Username,
+		user.Fullname,
+		user.Phone,
+		user.Address,
+		user.DistrictId,
+		user.UserTypeId,
+		user.Activated,
+		user.ID,
+		user.Version,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Username)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq :duplicate key values violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
-			return err
+			return userError(err)
 		}
 	}
 
 	return nil
 }
+
 func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error) {
 
-	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+	tokenHash := TokenHash(tokenPlainText)
 	//setup query
 	query := `
 
@@ -350,7 +367,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 		WHERE tokens.hash = $1 AND tokens.scope = $2
 		AND tokens.expiry > $3
 	`
-	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+	args := []interface{}{tokenHash, tokenScope, time.Now()}
 
 	var user User
 
@@ -386,6 +403,64 @@ func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error)
 
 }
 
+// SetPendingEmail() keeps the new email address until the user confirms it, the address
+// must not belong to another account
+func (m UserModel) SetPendingEmail(userID int64, email string) error {
+	query := `
+		UPDATE users
+		SET pending_email = $2
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM users WHERE email = $2 AND id <> $1)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, email)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDuplicateEmail
+	}
+
+	return nil
+}
+
+// ConfirmPendingEmail() swaps the user's email for the pending one and returns the old
+// address so it can be told about the change
+func (m UserModel) ConfirmPendingEmail(user *User) (string, error) {
+	query := `
+		UPDATE users u
+		SET email = u.pending_email, pending_email = NULL
+		FROM (SELECT id, email FROM users WHERE id = $1 FOR UPDATE) old
+		WHERE u.id = old.id AND u.pending_email IS NOT NULL
+		RETURNING old.email, u.email
+	`
+
+	var oldEmail string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID).Scan(&oldEmail, &user.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", userError(err)
+		}
+	}
+
+	return oldEmail, nil
+}
+
 // get user based on their username
 func (m UserModel) GetByUsername(username string) (*User, error) {
 
@@ -430,6 +505,88 @@ func (m UserModel) GetByUsername(username string) (*User, error) {
 	return &user, nil
 }
 
+// get user based on their id
+func (m UserModel) GetByID(id int64) (*User, error) {
+
+	query := `
+		SELECT id, username, password_hash, fullname, email,phone, address, districtid,usertypeid,activated, created_at, version
+		FROM users
+		WHERE id = $1
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Password.hash,
+		&user.Fullname,
+		&user.Email,
+		&user.Phone,
+		&user.Address,
+		&user.DistrictId,
+		&user.UserTypeId,
+		&user.Activated,
+		&user.CreatedAt,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// get user based on their email, closed accounts are left out so they cannot be
+// reopened with a password reset or activation email
+func (m UserModel) GetByEmail(email string) (*User, error) {
+
+	query := `
+		SELECT id, username, password_hash, fullname, email,phone, address, districtid,usertypeid,activated, created_at
+		FROM users
+		WHERE email = $1 AND closed_at IS NULL
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Password.hash,
+		&user.Fullname,
+		&user.Email,
+		&user.Phone,
+		&user.Address,
+		&user.DistrictId,
+		&user.UserTypeId,
+		&user.Activated,
+		&user.CreatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // Get () allow us to retrieve a specific listing
 func (m UserModel) Get(id int64) (*UserListing, error) {
 
@@ -442,7 +599,7 @@ func (m UserModel) Get(id int64) (*UserListing, error) {
 	query := `
 
 	SELECT u.id, u.username, u.password_hash,u.fullname, u.email, u.phone, u.address, d.name as district, ut.name as usertype,
-		u.activated, img.image_url, u.created_at
+		u.activated, img.image_url, u.created_at, u.version
 		FROM users u inner join userprofileimage img
 		on u.id = img.user_id
 		inner join district d 
@@ -477,6 +634,7 @@ func (m UserModel) Get(id int64) (*UserListing, error) {
 		&userlisting.Activated,
 		&userlisting.ProfileImage,
 		&userlisting.CreatedAt,
+		&userlisting.Version,
 	)
 
 	if err != nil {
@@ -0,0 +1,250 @@
+//Filename: internal/data/valuation.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+var (
+	ErrNoComparables = errors.New("no comparable sales found")
+)
+
+// the fewest comparable sales an estimate will be made from
+const minComparables = 3
+
+// ValuationSubject is the property being valued
+type ValuationSubject struct {
+	ListingID      int64   `json:"listing_id,omitempty"`
+	DistrictId     int64   `json:"district_id"`
+	PropertyTypeId int64   `json:"property_type_id"`
+	Area           float64 `json:"area"`
+	ListingType    string  `json:"-"`
+}
+
+// Comparable is a sold listing used to value the subject
+type Comparable struct {
+	ListingID     int64     `json:"listing_id"`
+	PropertyTitle string    `json:"property_title"`
+	District      string    `json:"district"`
+	PropertyType  string    `json:"property_type"`
+	Area          float64   `json:"area"`
+	ListPrice     float64   `json:"list_price"`
+	SalePrice     float64   `json:"sale_price"`
+	CloseDate     time.Time `json:"close_date"`
+	PricePerArea  float64   `json:"price_per_area"`
+}
+
+// PricePerAreaStats summarises the price per square foot of the comparables
+type PricePerAreaStats struct {
+	Min    float64 `json:"min"`
+	Q1     float64 `json:"q1"`
+	Median float64 `json:"median"`
+	Q3     float64 `json:"q3"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+}
+
+// Valuation is the estimated value of the subject and the sales it was based on
+type Valuation struct {
+	Subject        ValuationSubject  `json:"subject"`
+	EstimatedValue float64           `json:"estimated_value"`
+	EstimateLow    float64           `json:"estimate_low"`
+	EstimateHigh   float64           `json:"estimate_high"`
+	PricePerArea   PricePerAreaStats `json:"price_per_area"`
+	Comparables    []*Comparable     `json:"comparables"`
+}
+
+func ValidateValuationSubject(v *validator.Validator, subject *ValuationSubject) {
+	v.Check(subject.DistrictId > 0, "district_id", "must be provided")
+	v.Check(subject.PropertyTypeId > 0, "property_type_id", "must be provided")
+	v.Check(subject.Area > 0, "area", "must be greater than zero")
+}
+
+// EstimateValue() prices the subject from the median price per area of the
+// comparables, the range is taken from the lower and upper quartiles
+func EstimateValue(subject ValuationSubject, comps []*Comparable) (*Valuation, error) {
+
+	if len(comps) < minComparables {
+		return nil, ErrNoComparables
+	}
+
+	prices := make([]float64, len(comps))
+	total := 0.0
+	for i, comp := range comps {
+		prices[i] = comp.PricePerArea
+		total += comp.PricePerArea
+	}
+	sort.Float64s(prices)
+
+	stats := PricePerAreaStats{
+		Min:    prices[0],
+		Q1:     percentile(prices, 0.25),
+		Median: percentile(prices, 0.5),
+		Q3:     percentile(prices, 0.75),
+		Max:    prices[len(prices)-1],
+		Mean:   round2(total / float64(len(prices))),
+	}
+
+	return &Valuation{
+		Subject:        subject,
+		EstimatedValue: round2(stats.Median * subject.Area),
+		EstimateLow:    round2(stats.Q1 * subject.Area),
+		EstimateHigh:   round2(stats.Q3 * subject.Area),
+		PricePerArea:   stats,
+		Comparables:    comps,
+	}, nil
+}
+
+// percentile() interpolates between the closest ranks of a sorted slice
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+
+	return round2(sorted[lower]*(1-weight) + sorted[upper]*weight)
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// Define a ValuationModel which wrap a sql.DB connection pool
+type ValuationModel struct {
+	DB *sql.DB
+}
+
+// GetSubject() returns the district, type and area of a listing to be valued
+func (m ValuationModel) GetSubject(listingID int64) (*ValuationSubject, error) {
+
+	//Ensure that there is a valid id
+	if listingID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, districtid, propertytypeid, area, listing_type
+		FROM listing
+		WHERE id = $1
+	`
+
+	var subject ValuationSubject
+
+	//listings can be saved without a district or type, they are left at zero
+	//so the subject fails validation instead
+	var districtID, propertyTypeID sql.NullInt64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, listingID).Scan(
+		&subject.ListingID,
+		&districtID,
+		&propertyTypeID,
+		&subject.Area,
+		&subject.ListingType,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	subject.DistrictId = districtID.Int64
+	subject.PropertyTypeId = propertyTypeID.Int64
+
+	return &subject, nil
+}
+
+// FindComparables() looks for sales of the same type in the same district, starting
+// with the last year and widening to three years when there are too few
+func (m ValuationModel) FindComparables(subject ValuationSubject) ([]*Comparable, error) {
+
+	var comps []*Comparable
+	var err error
+
+	for _, months := range []int{12, 36} {
+		comps, err = m.findComparables(subject, time.Now().AddDate(0, -months, 0))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(comps) >= minComparables {
+			break
+		}
+	}
+
+	return comps, nil
+}
+
+func (m ValuationModel) findComparables(subject ValuationSubject, since time.Time) ([]*Comparable, error) {
+
+	//sales between half and one and a half times the size, closest in size first
+	query := `
+	SELECT l.id, l.propertytitle, d.name, pt.name, l.area, l.price, c.sale_price, c.close_date
+	FROM closings c inner join listing l on l.id = c.listing_id
+	inner join district d on l.districtid = d.id
+	inner join propertytype pt on l.propertytypeid = pt.id
+	WHERE c.lease_term_months IS NULL
+	AND l.districtid = $1 AND l.propertytypeid = $2
+	AND l.area BETWEEN $3 * 0.5 AND $3 * 1.5
+	AND l.area > 0
+	AND c.close_date >= $4
+	AND l.id <> $5
+	ORDER BY abs(l.area - $3), c.close_date DESC
+	LIMIT 10
+	`
+
+	args := []interface{}{subject.DistrictId, subject.PropertyTypeId, subject.Area, since, subject.ListingID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	comps := []*Comparable{}
+
+	for rows.Next() {
+		var comp Comparable
+		err := rows.Scan(
+			&comp.ListingID,
+			&comp.PropertyTitle,
+			&comp.District,
+			&comp.PropertyType,
+			&comp.Area,
+			&comp.ListPrice,
+			&comp.SalePrice,
+			&comp.CloseDate,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		comp.PricePerArea = round2(comp.SalePrice / comp.Area)
+		comps = append(comps, &comp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comps, nil
+}
@@ -0,0 +1,126 @@
+//Filename: internal/finance/mortgage.go
+
+package finance
+
+import (
+	"math"
+
+	"realestatebelize.imerlopez.net/internal/validator"
+)
+
+// Loan holds the terms of a mortgage, rates and yearly costs are annual
+type Loan struct {
+	Price             float64 `json:"price"`
+	DownPayment       float64 `json:"down_payment"`
+	AnnualRate        float64 `json:"annual_rate"`
+	TermYears         int     `json:"term_years"`
+	AnnualPropertyTax float64 `json:"annual_property_tax"`
+	AnnualInsurance   float64 `json:"annual_insurance"`
+}
+
+// Payment is a single row of the amortization schedule
+type Payment struct {
+	Number    int     `json:"number"`
+	Payment   float64 `json:"payment"`
+	Principal float64 `json:"principal"`
+	Interest  float64 `json:"interest"`
+	Balance   float64 `json:"balance"`
+}
+
+// Mortgage is the monthly cost of a loan and, optionally, its full schedule
+type Mortgage struct {
+	Loan                        Loan      `json:"loan"`
+	LoanAmount                  float64   `json:"loan_amount"`
+	Payments                    int       `json:"payments"`
+	MonthlyPrincipalAndInterest float64   `json:"monthly_principal_and_interest"`
+	MonthlyPropertyTax          float64   `json:"monthly_property_tax"`
+	MonthlyInsurance            float64   `json:"monthly_insurance"`
+	MonthlyPayment              float64   `json:"monthly_payment"`
+	TotalInterest               float64   `json:"total_interest"`
+	TotalPaid                   float64   `json:"total_paid"`
+	Schedule                    []Payment `json:"schedule,omitempty"`
+}
+
+func ValidateLoan(v *validator.Validator, loan Loan) {
+	v.Check(loan.Price > 0, "price", "must be greater than zero")
+
+	v.Check(loan.DownPayment >= 0, "down_payment", "must not be negative")
+	v.Check(loan.DownPayment < loan.Price, "down_payment", "must be less than the price")
+
+	v.Check(loan.AnnualRate >= 0, "annual_rate", "must not be negative")
+	v.Check(loan.AnnualRate <= 50, "annual_rate", "must not be more than 50 percent")
+
+	v.Check(loan.TermYears > 0, "term_years", "must be greater than zero")
+	v.Check(loan.TermYears <= 40, "term_years", "must not be more than 40 years")
+
+	v.Check(loan.AnnualPropertyTax >= 0, "annual_property_tax", "must not be negative")
+	v.Check(loan.AnnualInsurance >= 0, "annual_insurance", "must not be negative")
+}
+
+// MonthlyPayment returns the fixed principal and interest payment of a loan,
+// annualRate is a percentage e.g. 7.5
+func MonthlyPayment(principal, annualRate float64, months int) float64 {
+	if months <= 0 {
+		return 0
+	}
+
+	r := annualRate / 100 / 12
+	if r == 0 {
+		return principal / float64(months)
+	}
+
+	return principal * r / (1 - math.Pow(1+r, -float64(months)))
+}
+
+// Calculate works out the monthly cost of the loan, the schedule is only
+// filled in when withSchedule is true
+func Calculate(loan Loan, withSchedule bool) Mortgage {
+
+	months := loan.TermYears * 12
+	principal := loan.Price - loan.DownPayment
+	payment := roundCents(MonthlyPayment(principal, loan.AnnualRate, months))
+	rate := loan.AnnualRate / 100 / 12
+
+	m := Mortgage{
+		Loan:                        loan,
+		LoanAmount:                  roundCents(principal),
+		Payments:                    months,
+		MonthlyPrincipalAndInterest: payment,
+		MonthlyPropertyTax:          roundCents(loan.AnnualPropertyTax / 12),
+		MonthlyInsurance:            roundCents(loan.AnnualInsurance / 12),
+	}
+	m.MonthlyPayment = roundCents(m.MonthlyPrincipalAndInterest + m.MonthlyPropertyTax + m.MonthlyInsurance)
+
+	//walk the schedule to get the exact interest, the last payment clears what is left
+	balance := roundCents(principal)
+	for n := 1; n <= months; n++ {
+		interest := roundCents(balance * rate)
+		toPrincipal := roundCents(payment - interest)
+		if n == months || toPrincipal > balance {
+			toPrincipal = balance
+		}
+		balance = roundCents(balance - toPrincipal)
+
+		m.TotalInterest += interest
+		m.TotalPaid += toPrincipal + interest
+
+		if withSchedule {
+			m.Schedule = append(m.Schedule, Payment{
+				Number:    n,
+				Payment:   roundCents(toPrincipal + interest),
+				Principal: toPrincipal,
+				Interest:  interest,
+				Balance:   balance,
+			})
+		}
+	}
+
+	m.TotalInterest = roundCents(m.TotalInterest)
+	m.TotalPaid = roundCents(m.TotalPaid)
+
+	return m
+}
+
+func roundCents(f float64) float64 {
+	return math.Round(f*100) / 100
+}
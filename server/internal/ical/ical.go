@@ -0,0 +1,241 @@
+//Filename: internal/ical/ical.go
+
+// Package ical reads and writes the small part of iCalendar (RFC 5545) that
+// booking calendars use: all-day VEVENTs with a UID, SUMMARY, DTSTART and DTEND
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+
+	// ContentType is the media type of an .ics file
+	ContentType = "text/calendar; charset=utf-8"
+)
+
+var (
+	ErrNoCalendar = errors.New("ical: no VCALENDAR found")
+)
+
+// Event is a booked or blocked range of nights, End is exclusive as in DTEND
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// Parse reads the VEVENTs of a calendar, times are truncated to their date and
+// an event without a DTEND lasts one night
+func Parse(r io.Reader) ([]Event, error) {
+
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var current *Event
+	var inCalendar bool
+
+	for n, line := range lines {
+		name, params, value := splitLine(line)
+
+		switch {
+		case name == "BEGIN" && value == "VCALENDAR":
+			inCalendar = true
+
+		case name == "BEGIN" && value == "VEVENT":
+			current = &Event{}
+
+		case name == "END" && value == "VEVENT":
+			if current == nil {
+				return nil, fmt.Errorf("ical: line %d: END:VEVENT without BEGIN", n+1)
+			}
+			if current.Start.IsZero() {
+				return nil, fmt.Errorf("ical: line %d: event %q has no DTSTART", n+1, current.UID)
+			}
+			if current.End.IsZero() {
+				current.End = current.Start.AddDate(0, 0, 1)
+			}
+			if !current.End.After(current.Start) {
+				return nil, fmt.Errorf("ical: line %d: event %q ends before it starts", n+1, current.UID)
+			}
+			events = append(events, *current)
+			current = nil
+
+		case current == nil:
+			//properties of the calendar itself or of other components
+
+		case name == "UID":
+			current.UID = value
+
+		case name == "SUMMARY":
+			current.Summary = unescape(value)
+
+		case name == "DTSTART", name == "DTEND":
+			t, err := parseDate(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("ical: line %d: %w", n+1, err)
+			}
+			if name == "DTSTART" {
+				current.Start = t
+			} else {
+				current.End = t
+			}
+		}
+	}
+
+	if !inCalendar {
+		return nil, ErrNoCalendar
+	}
+
+	if current != nil {
+		return nil, errors.New("ical: unterminated VEVENT")
+	}
+
+	return events, nil
+}
+
+// Write writes the events as a calendar named name, every event is all-day
+func Write(w io.Writer, name string, events []Event) error {
+
+	bw := bufio.NewWriter(w)
+	stamp := time.Now().UTC().Format(dateTimeLayout) + "Z"
+
+	writeLine(bw, "BEGIN:VCALENDAR")
+	writeLine(bw, "VERSION:2.0")
+	writeLine(bw, "PRODID:-//Real Estate Belize//Listing Calendar//EN")
+	writeLine(bw, "CALSCALE:GREGORIAN")
+	writeLine(bw, "X-WR-CALNAME:"+escape(name))
+
+	for _, event := range events {
+		writeLine(bw, "BEGIN:VEVENT")
+		writeLine(bw, "UID:"+event.UID)
+		writeLine(bw, "DTSTAMP:"+stamp)
+		writeLine(bw, "DTSTART;VALUE=DATE:"+event.Start.Format(dateLayout))
+		writeLine(bw, "DTEND;VALUE=DATE:"+event.End.Format(dateLayout))
+		writeLine(bw, "SUMMARY:"+escape(event.Summary))
+		writeLine(bw, "END:VEVENT")
+	}
+
+	writeLine(bw, "END:VCALENDAR")
+
+	return bw.Flush()
+}
+
+// unfold joins continuation lines (those starting with a space or tab) onto the line before
+func unfold(r io.Reader) ([]string, error) {
+
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		if line == "" {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitLine breaks NAME;PARAM=X:VALUE into its parts, names are upper cased
+func splitLine(line string) (string, map[string]string, string) {
+
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+
+	head, value := line[:colon], line[colon+1:]
+	parts := strings.Split(head, ";")
+
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		if eq := strings.IndexByte(p, '='); eq > 0 {
+			params[strings.ToUpper(p[:eq])] = strings.Trim(p[eq+1:], `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, value
+}
+
+// parseDate reads a DATE or DATE-TIME value and returns midnight UTC of its date,
+// date-times with a TZID are read in that zone before being truncated
+func parseDate(params map[string]string, value string) (time.Time, error) {
+
+	if params["VALUE"] == "DATE" || len(value) == len(dateLayout) {
+		t, err := time.Parse(dateLayout, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", value)
+		}
+		return t, nil
+	}
+
+	loc := time.UTC
+	if strings.HasSuffix(value, "Z") {
+		value = strings.TrimSuffix(value, "Z")
+	} else if tzid, ok := params["TZID"]; ok {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	t, err := time.ParseInLocation(dateTimeLayout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date-time %q", value)
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// writeLine folds lines longer than 75 octets without splitting a utf-8 character
+func writeLine(w *bufio.Writer, line string) {
+
+	//continuation lines lose an octet to the leading space
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
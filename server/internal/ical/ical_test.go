@@ -0,0 +1,179 @@
+//Filename: internal/ical/ical_test.go
+
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestParseFixtures(t *testing.T) {
+
+	tests := []struct {
+		fixture string
+		want    []Event
+	}{
+		{
+			fixture: "airbnb.ics",
+			want: []Event{
+				{UID: "1418fb94e984-8f3b7e2c41a9d0e5@airbnb.com", Summary: "Reserved", Start: date("2026-11-01"), End: date("2026-11-05")},
+				{UID: "1418fb94e984-0b52c6e1f7a3d948@airbnb.com", Summary: "Airbnb (Not available)", Start: date("2026-11-20"), End: date("2026-11-24")},
+			},
+		},
+		{
+			//date-times are taken on their date in their own zone, the VTIMEZONE is skipped,
+			//the summary is folded and escaped and an event without DTEND lasts one night
+			fixture: "vrbo.ics",
+			want: []Event{
+				{UID: "62a1f0c4-owner-block@vrbo.com", Summary: "Owner stay, family visiting; back on the 13th", Start: date("2026-12-10"), End: date("2026-12-13")},
+				{UID: "62a1f0c4-single-night@vrbo.com", Summary: "Christmas", Start: date("2026-12-25"), End: date("2026-12-26")},
+			},
+		},
+		{
+			fixture: "lf.ics",
+			want: []Event{
+				{UID: "lf-only", Summary: "Unix line endings", Start: date("2027-01-02"), End: date("2027-01-04")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+
+			events, err := Parse(bytes.NewReader(readFixture(t, tt.fixture)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(events) != len(tt.want) {
+				t.Fatalf("got %d events, want %d: %+v", len(events), len(tt.want), events)
+			}
+
+			for i := range tt.want {
+				got, want := events[i], tt.want[i]
+				if got.UID != want.UID || got.Summary != want.Summary || !got.Start.Equal(want.Start) || !got.End.Equal(want.End) {
+					t.Errorf("event %d:\ngot  %+v\nwant %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{"not a calendar", "hello", ErrNoCalendar},
+		{"no start", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", nil},
+		{"ends before it starts", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART:20261105\r\nDTEND:20261101\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", nil},
+		{"bad date", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART;VALUE=DATE:2026-11-01\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", nil},
+		{"unterminated event", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART:20261101\r\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, err := Parse(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// the stamp changes every run so it is taken out before comparing
+var dtstamp = regexp.MustCompile(`DTSTAMP:\d{8}T\d{6}Z`)
+
+func TestWriteMatchesFixture(t *testing.T) {
+
+	events := []Event{
+		{UID: "booking-7@api.example.com", Summary: "Booked", Start: date("2026-11-01"), End: date("2026-11-05")},
+		{UID: "block-3@api.example.com", Summary: "Not available, the owner is doing repairs; the pool is being retiled", Start: date("2026-11-20"), End: date("2026-11-24")},
+	}
+
+	var buf bytes.Buffer
+
+	err := Write(&buf, "Villa, Placencia", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := dtstamp.ReplaceAllString(buf.String(), "DTSTAMP:20000101T000000Z")
+	want := string(readFixture(t, "export.ics"))
+
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, line := range strings.Split(got, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is longer than 75 octets: %q", line)
+		}
+	}
+}
+
+func TestWriteParseRoundTrip(t *testing.T) {
+
+	events, err := Parse(bytes.NewReader(readFixture(t, "vrbo.ics")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//multi byte characters must not be split when the line is folded
+	events[0].Summary = strings.Repeat("Cabaña, río; ", 10)
+
+	var buf bytes.Buffer
+
+	err = Write(&buf, "Cabaña", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	back, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(back) != len(events) {
+		t.Fatalf("got %d events, want %d", len(back), len(events))
+	}
+
+	for i := range events {
+		if back[i] != events[i] {
+			t.Errorf("event %d:\ngot  %+v\nwant %+v", i, back[i], events[i])
+		}
+	}
+}
@@ -31,8 +31,14 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
+// Attachment is a file sent along with the mail
+type Attachment struct {
+	Filename string
+	Content  []byte
+}
+
 // send a mail
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+func (m Mailer) Send(recipient, templateFile string, data interface{}, attachments ...Attachment) error {
 
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 
@@ -69,6 +75,10 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
 
+	for _, attachment := range attachments {
+		msg.AttachReader(attachment.Filename, bytes.NewReader(attachment.Content))
+	}
+
 	//call dailAndSend()
 	err = m.dailer.DialAndSend(msg)
 
@@ -0,0 +1,473 @@
+//Filename: internal/oidc/oidc.go
+
+// Package oidc is a small OpenID Connect relying party for the authorization code
+// flow with PKCE. It reads the provider's discovery document, exchanges the code and
+// checks the RS256 signed ID token against the provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long discovery documents and keys are trusted before they are fetched again
+const cacheFor = time.Hour
+
+// clock skew allowed when checking token times
+const leeway = time.Minute
+
+// the largest response read from a provider
+const maxResponseSize = 1 << 20
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid id token")
+	ErrExchange       = errors.New("oidc: code exchange failed")
+)
+
+// Config describes an identity provider
+type Config struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+	// new accounts are only made when AllowSignup is set, for emails in AllowedDomains
+	// if any are given, using the district and user type below
+	AllowSignup    bool     `json:"allow_signup"`
+	AllowedDomains []string `json:"allowed_domains"`
+	DistrictID     int64    `json:"district_id"`
+	UserTypeID     int64    `json:"user_type_id"`
+}
+
+// AllowsEmail reports whether an account may be made for email
+func (c Config) AllowsEmail(email string) bool {
+
+	if len(c.AllowedDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range c.AllowedDomains {
+		if strings.ToLower(allowed) == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadConfig reads a JSON array of providers from a file
+func LoadConfig(path string) ([]Config, error) {
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []Config
+	err = json.Unmarshal(b, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range configs {
+		switch {
+		case c.Name == "" || c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "":
+			return nil, fmt.Errorf("oidc: %s: every provider needs a name, issuer, client_id and redirect_url", path)
+		case seen[c.Name]:
+			return nil, fmt.Errorf("oidc: %s: duplicate provider %q", path, c.Name)
+		}
+		seen[c.Name] = true
+	}
+
+	return configs, nil
+}
+
+// Claims are the parts of an ID token we use
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one identity provider
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *discovery
+	discoveryAt time.Time
+	keys        map[string]*rsa.PublicKey
+	keysAt      time.Time
+}
+
+// New returns a provider, nothing is fetched until it is first used
+func New(config Config, client *http.Client) *Provider {
+
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Provider{config: config, client: client}
+}
+
+// Config returns the configuration of the provider
+func (p *Provider) Config() Config {
+	return p.config
+}
+
+// RandomString returns a random URL safe string for states, nonces and PKCE verifiers
+func RandomString() (string, error) {
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE challenge of a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns where to send the user to sign in
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := append([]string{"openid"}, p.config.Scopes...)
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange swaps an authorization code for the claims of the user's ID token
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = p.do(req, &token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+
+	return p.verify(ctx, token.IDToken, nonce, time.Now())
+}
+
+// verify checks the signature, issuer, audience, times and nonce of an ID token
+func (p *Provider) verify(ctx context.Context, raw, nonce string, now time.Time) (*Claims, error) {
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "RS256" {
+		return nil, ErrInvalidIDToken
+	}
+
+	key, err := p.getKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature) != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims struct {
+		Issuer        string          `json:"iss"`
+		Subject       string          `json:"sub"`
+		Audience      audience        `json:"aud"`
+		AZP           string          `json:"azp"`
+		Expiry        int64           `json:"exp"`
+		IssuedAt      int64           `json:"iat"`
+		Nonce         string          `json:"nonce"`
+		Email         string          `json:"email"`
+		EmailVerified json.RawMessage `json:"email_verified"`
+		Name          string          `json:"name"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claims.Issuer != d.Issuer:
+	case !claims.Audience.contains(p.config.ClientID):
+	case len(claims.Audience) > 1 && claims.AZP != p.config.ClientID:
+	case now.After(time.Unix(claims.Expiry, 0).Add(leeway)):
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(leeway)):
+	case claims.Nonce != nonce:
+	case claims.Subject == "":
+	default:
+		return &Claims{
+			Subject: claims.Subject,
+			Email:   claims.Email,
+			//some providers send the flag as a string
+			EmailVerified: string(claims.EmailVerified) == "true" || string(claims.EmailVerified) == `"true"`,
+			Name:          claims.Name,
+		}, nil
+	}
+
+	return nil, ErrInvalidIDToken
+}
+
+// audience is a JWT aud claim, a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
+
+// getDiscovery returns the provider's discovery document, fetching it when stale
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveryAt) < cacheFor {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var d discovery
+	err = p.do(req, &d)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery for %s: %w", p.config.Name, err)
+	}
+
+	//the document must be for the issuer we were configured with
+	if d.Issuer != p.config.Issuer || d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery for %s: issuer or endpoints do not match", p.config.Name)
+	}
+
+	p.discovery = &d
+	p.discoveryAt = time.Now()
+
+	return p.discovery, nil
+}
+
+// getKey returns the signing key kid, the key set is fetched again when the kid is
+// unknown so provider key rotation is picked up
+func (p *Provider) getKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok && time.Since(p.keysAt) < cacheFor {
+		return key, nil
+	}
+
+	//do not let tokens with made up key ids hammer the provider
+	if p.keys != nil && time.Since(p.keysAt) < 10*time.Second {
+		return nil, ErrInvalidIDToken
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err = p.do(req, &set)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: keys for %s: %w", p.config.Name, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.keys = keys
+	p.keysAt = time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+
+	return key, nil
+}
+
+// do sends a request and decodes the JSON response
+func (p *Provider) do(req *http.Request, dst interface{}) error {
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, res.Status)
+	}
+
+	return json.Unmarshal(body, dst)
+}
@@ -0,0 +1,429 @@
+//Filename: internal/oidc/oidc_test.go
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockProvider is an identity provider with a discovery document, a key set and a
+// token endpoint that hands out an ID token for the code "good"
+type mockProvider struct {
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	issuer    string
+	key       *rsa.PrivateKey
+	kid       string
+	claims    map[string]interface{}
+	challenge string
+	jwksHits  int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	m := &mockProvider{kid: "k1", key: newKey(t)}
+
+	mux := http.NewServeMux()
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+
+	m.issuer = m.srv.URL
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.issuer,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.jwksHits++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": m.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		r.ParseForm()
+		id, secret, _ := r.BasicAuth()
+
+		switch {
+		case r.Form.Get("grant_type") != "authorization_code", r.Form.Get("redirect_uri") != "https://app.example/v1/oidc/acme/callback":
+		case id != "client" || secret != "s3cret":
+		case r.Form.Get("code") != "good":
+		case Challenge(r.Form.Get("code_verifier")) != m.challenge:
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(m.claims)})
+			return
+		}
+
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	return m
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// sign makes an RS256 token with the current key, the caller holds the lock
+func (m *mockProvider) sign(claims map[string]interface{}) string {
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": m.kid})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (m *mockProvider) setClaims(claims map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.claims = claims
+}
+
+// validClaims are the claims of a good ID token for the nonce
+func (m *mockProvider) validClaims(nonce string) map[string]interface{} {
+	now := time.Now().Unix()
+
+	return map[string]interface{}{
+		"iss":            m.issuer,
+		"sub":            "248289761001",
+		"aud":            "client",
+		"exp":            now + 300,
+		"iat":            now,
+		"nonce":          nonce,
+		"email":          "jane@acme.bz",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+}
+
+func (m *mockProvider) provider() *Provider {
+	return New(Config{
+		Name:         "acme",
+		Issuer:       m.srv.URL,
+		ClientID:     "client",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://app.example/v1/oidc/acme/callback",
+		Scopes:       []string{"email", "profile"},
+	}, nil)
+}
+
+// start begins a sign in like the login handler does and returns the verifier and
+// nonce the callback needs
+func (m *mockProvider) start(t *testing.T, p *Provider) (string, string) {
+	t.Helper()
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.AuthCodeURL(context.Background(), "the-state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	m.challenge = u.Query().Get("code_challenge")
+	m.mu.Unlock()
+
+	return verifier, nonce
+}
+
+func TestAuthCodeURL(t *testing.T) {
+
+	m := newMockProvider(t)
+	p := m.provider()
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.AuthCodeURL(context.Background(), "the-state", "the-nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(authURL, m.srv.URL+"/authorize?") {
+		t.Fatalf("got %s, want the discovered authorization endpoint", authURL)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"redirect_uri":          "https://app.example/v1/oidc/acme/callback",
+		"scope":                 "openid email profile",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        Challenge(verifier),
+		"code_challenge_method": "S256",
+	}
+
+	for name, value := range want {
+		if got := u.Query().Get(name); got != value {
+			t.Errorf("%s: got %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+
+	m := newMockProvider(t)
+	m.issuer = "https://someone-else.example"
+
+	_, err := m.provider().AuthCodeURL(context.Background(), "s", "n", "v")
+	if err == nil || !strings.Contains(err.Error(), "do not match") {
+		t.Fatalf("got %v, want the discovery document to be refused", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+
+	m := newMockProvider(t)
+	p := m.provider()
+
+	verifier, nonce := m.start(t, p)
+	m.setClaims(m.validClaims(nonce))
+
+	claims, err := p.Exchange(context.Background(), "good", verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Claims{Subject: "248289761001", Email: "jane@acme.bz", EmailVerified: true, Name: "Jane Doe"}
+	if *claims != want {
+		t.Fatalf("got %+v, want %+v", *claims, want)
+	}
+
+	//a code is only swapped with the verifier it was started with
+	_, err = p.Exchange(context.Background(), "good", "some-other-verifier", nonce)
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("wrong verifier: got %v, want %v", err, ErrExchange)
+	}
+
+	_, err = p.Exchange(context.Background(), "bad", verifier, nonce)
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("wrong code: got %v, want %v", err, ErrExchange)
+	}
+}
+
+func TestExchangeRejectsIDTokens(t *testing.T) {
+
+	m := newMockProvider(t)
+	p := m.provider()
+
+	verifier, nonce := m.start(t, p)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name  string
+		claim string
+		value interface{}
+	}{
+		{"another audience", "aud", "someone-else"},
+		{"another issuer", "iss", "https://someone-else.example"},
+		{"expired", "exp", now - 300},
+		{"issued in the future", "iat", now + 300},
+		{"another nonce", "nonce", "replayed"},
+		{"no subject", "sub", ""},
+		{"several audiences without azp", "aud", []string{"client", "someone-else"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			claims := m.validClaims(nonce)
+			claims[tt.claim] = tt.value
+			m.setClaims(claims)
+
+			_, err := p.Exchange(context.Background(), "good", verifier, nonce)
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("got %v, want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestExchangeAcceptsProviderQuirks(t *testing.T) {
+
+	m := newMockProvider(t)
+	p := m.provider()
+
+	verifier, nonce := m.start(t, p)
+
+	//several audiences with the azp set, and email_verified sent as a string
+	claims := m.validClaims(nonce)
+	claims["aud"] = []string{"client", "someone-else"}
+	claims["azp"] = "client"
+	claims["email_verified"] = "true"
+	m.setClaims(claims)
+
+	got, err := p.Exchange(context.Background(), "good", verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.EmailVerified {
+		t.Error("email_verified sent as a string was not read")
+	}
+}
+
+func TestVerifyRejectsTamperedSignature(t *testing.T) {
+
+	m := newMockProvider(t)
+	p := m.provider()
+
+	m.mu.Lock()
+	raw := m.sign(m.validClaims("n"))
+	m.mu.Unlock()
+
+	parts := strings.Split(raw, ".")
+
+	//swap in other claims under the same signature
+	payload, _ := json.Marshal(map[string]interface{}{"sub": "admin"})
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	_, err := p.verify(context.Background(), forged, "n", time.Now())
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidIDToken)
+	}
+
+	//a token signed by a key the provider does not publish
+	m.mu.Lock()
+	m.key = newKey(t)
+	other := m.sign(m.validClaims("n"))
+	m.mu.Unlock()
+
+	_, err = p.verify(context.Background(), other, "n", time.Now())
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidIDToken)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+
+	m := newMockProvider(t)
+	p := m.provider()
+
+	verifier, nonce := m.start(t, p)
+	m.setClaims(m.validClaims(nonce))
+
+	_, err := p.Exchange(context.Background(), "good", verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the provider rotates to a new key id
+	m.mu.Lock()
+	m.key = newKey(t)
+	m.kid = "k2"
+	m.mu.Unlock()
+
+	//unknown key ids right after a fetch are refused without asking the provider again
+	_, err = p.Exchange(context.Background(), "good", verifier, nonce)
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidIDToken)
+	}
+
+	//a while later the new key set is fetched
+	p.mu.Lock()
+	p.keysAt = time.Now().Add(-time.Minute)
+	p.mu.Unlock()
+
+	_, err = p.Exchange(context.Background(), "good", verifier, nonce)
+	if err != nil {
+		t.Fatalf("the rotated key was not picked up: %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.jwksHits != 2 {
+		t.Errorf("the key set was fetched %d times, want 2", m.jwksHits)
+	}
+}
+
+func TestAllowsEmail(t *testing.T) {
+
+	tests := []struct {
+		domains []string
+		email   string
+		allowed bool
+	}{
+		{nil, "jane@anywhere.example", true},
+		{[]string{"Acme.bz"}, "jane@ACME.bz", true},
+		{[]string{"acme.bz"}, "jane@evil.bz", false},
+		{[]string{"acme.bz"}, "jane@acme.bz.evil.bz", false},
+		{[]string{"acme.bz"}, "no-at-sign", false},
+	}
+
+	for _, tt := range tests {
+		if got := (Config{AllowedDomains: tt.domains}).AllowsEmail(tt.email); got != tt.allowed {
+			t.Errorf("%v %s: got %v, want %v", tt.domains, tt.email, got, tt.allowed)
+		}
+	}
+}
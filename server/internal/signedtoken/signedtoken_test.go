@@ -0,0 +1,212 @@
+//Filename: internal/signedtoken/signedtoken_test.go
+
+package signedtoken
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), minKeyLength)))
+}
+
+func mustParse(t *testing.T, spec string) *Keys {
+	t.Helper()
+
+	keys, err := ParseKeys(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keys
+}
+
+func TestSignAndVerify(t *testing.T) {
+
+	keys := mustParse(t, "k1:"+key('a'))
+
+	claims := &Claims{UserID: 42, Activated: true, Permissions: []string{"listings:read"}, MFA: true, Family: "f1"}
+
+	before := time.Now()
+
+	token, err := keys.Sign(claims, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !Looks(token) || !strings.HasPrefix(token, "k1.") {
+		t.Fatalf("unexpected token %s", token)
+	}
+
+	got, err := keys.Verify(token, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ID == "" || got.ID != claims.ID || got.UserID != 42 || !got.Activated || !got.MFA || got.Family != "f1" ||
+		len(got.Permissions) != 1 || got.Permissions[0] != "listings:read" {
+		t.Errorf("got %+v, want %+v", got, claims)
+	}
+
+	if got.Issued().Before(before.Truncate(time.Microsecond)) || got.Expiry != got.IssuedAt+15*60 {
+		t.Errorf("unexpected times iat=%d iat_us=%d exp=%d", got.IssuedAt, got.IssuedAtMicro, got.Expiry)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+
+	keys := mustParse(t, "k1:"+key('a'))
+
+	token, err := keys.Sign(&Claims{UserID: 42, Permissions: []string{"listings:read"}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"x","sub":1,"act":true,"perms":["users:write"],"iat":1,"exp":99999999999}`))
+	otherKey := mustParse(t, "k1:"+key('b'))
+	resigned, err := otherKey.Sign(&Claims{UserID: 1, Permissions: []string{"users:write"}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"claims swapped", parts[0] + "." + forged + "." + parts[2]},
+		{"signature changed", parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("not the signature"))},
+		{"signature not base64", parts[0] + "." + parts[1] + ".!!!"},
+		{"no signature", parts[0] + "." + parts[1] + "."},
+		{"signed with another key under the same kid", resigned},
+		{"too few parts", parts[0] + "." + parts[1]},
+		{"too many parts", token + ".x"},
+	}
+
+	for _, tt := range tests {
+		_, err := keys.Verify(tt.token, time.Now())
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrInvalid)
+		}
+	}
+}
+
+func TestVerifyRejectsUnknownFields(t *testing.T) {
+
+	keys := mustParse(t, "k1:"+key('a'))
+
+	payload := "k1." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":1,"exp":99999999999,"admin":true}`))
+	token := payload + "." + base64.RawURLEncoding.EncodeToString(sign(keys.keys["k1"], payload))
+
+	_, err := keys.Verify(token, time.Now())
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("got %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestVerifyUnknownKey(t *testing.T) {
+
+	keys := mustParse(t, "k1:"+key('a'))
+	other := mustParse(t, "k2:"+key('a'))
+
+	token, err := other.Sign(&Claims{UserID: 42}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = keys.Verify(token, time.Now())
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("got %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestVerifyExpiry(t *testing.T) {
+
+	keys := mustParse(t, "k1:"+key('a'))
+
+	token, err := keys.Sign(&Claims{UserID: 42}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := keys.Verify(token, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiry := time.Unix(claims.Expiry, 0)
+
+	if _, err := keys.Verify(token, expiry.Add(-time.Second)); err != nil {
+		t.Errorf("a second before expiry: got %v", err)
+	}
+
+	for _, at := range []time.Time{expiry, expiry.Add(time.Hour)} {
+		if _, err := keys.Verify(token, at); !errors.Is(err, ErrExpired) {
+			t.Errorf("at %v: got %v, want %v", at, err, ErrExpired)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+
+	old := mustParse(t, "old:"+key('a'))
+
+	oldToken, err := old.Sign(&Claims{UserID: 42}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the new key goes first, the old one still verifies what it signed
+	rotated := mustParse(t, "new:"+key('b')+", old:"+key('a'))
+
+	claims, err := rotated.Verify(oldToken, time.Now())
+	if err != nil || claims.UserID != 42 {
+		t.Fatalf("a token of the old key no longer verifies: %v", err)
+	}
+
+	newToken, err := rotated.Sign(&Claims{UserID: 43}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(newToken, "new.") {
+		t.Fatalf("new tokens are not signed with the first key: %s", newToken)
+	}
+
+	//once the old key is dropped its tokens stop working
+	dropped := mustParse(t, "new:"+key('b'))
+
+	if _, err := dropped.Verify(oldToken, time.Now()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, want %v", err, ErrUnknownKey)
+	}
+	if _, err := dropped.Verify(newToken, time.Now()); err != nil {
+		t.Errorf("a token of the new key no longer verifies: %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{"empty", ""},
+		{"no kid", ":" + key('a')},
+		{"no colon", key('a')},
+		{"dot in kid", "k.1:" + key('a')},
+		{"not base64", "k1:%%%"},
+		{"too short", "k1:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"duplicate kid", "k1:" + key('a') + ",k1:" + key('b')},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseKeys(tt.spec); err == nil {
+			t.Errorf("%s: %q was accepted", tt.name, tt.spec)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+//Filename: internal/totp/totp_test.go
+
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890" in base32
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+
+	//the last six digits of the eight digit SHA1 codes of Appendix B
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.code {
+			t.Errorf("at %d: got %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestCodeAcceptsPaddedAndLowercaseSecrets(t *testing.T) {
+
+	padded := base32.StdEncoding.EncodeToString([]byte("12345678901"))
+	if !strings.HasSuffix(padded, "=") {
+		t.Fatalf("%s was expected to be padded", padded)
+	}
+
+	want, err := Code(padded, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Code(strings.ToLower(strings.TrimRight(padded, "=")), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("an invalid secret was accepted")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name   string
+		step   int64
+		usable bool
+	}{
+		{"current step", step, true},
+		{"one step behind", step - 1, true},
+		{"one step ahead", step + 1, true},
+		{"two steps behind", step - 2, false},
+		{"two steps ahead", step + 2, false},
+	}
+
+	for _, tt := range tests {
+
+		code, err := Code(rfcSecret, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		matched, ok := Validate(rfcSecret, code, now)
+		if ok != tt.usable {
+			t.Errorf("%s: got ok=%v, want %v", tt.name, ok, tt.usable)
+			continue
+		}
+
+		//the matched step is what callers store to refuse replays
+		if ok && matched != tt.step {
+			t.Errorf("%s: matched step %d, want %d", tt.name, matched, tt.step)
+		}
+	}
+
+	for _, code := range []string{"", "28708", "2870820", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("code %q was accepted", code)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a == b {
+		t.Error("two secrets were the same")
+	}
+
+	key, err := encoding.DecodeString(a)
+	if err != nil || len(key) != secretSize {
+		t.Errorf("got a %d byte secret (%v), want %d", len(key), err, secretSize)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+
+	got := ProvisioningURI("Belize Real Estate", "jane@acme.bz", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/Belize%20Real%20Estate:jane@acme.bz?algorithm=SHA1&digits=6&issuer=Belize%20Real%20Estate&period=30&secret=JBSWY3DPEHPK3PXP"
+
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
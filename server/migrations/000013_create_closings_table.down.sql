@@ -0,0 +1,3 @@
+-- Filename: migrations/000013_create_closings_table.down.sql
+
+DROP TABLE IF EXISTS closings;
@@ -0,0 +1,14 @@
+-- Filename: migrations/000013_create_closings_table.up.sql
+
+CREATE TABLE
+    IF NOT EXISTS closings(
+        id bigserial PRIMARY KEY,
+        listing_id BIGINT UNIQUE NOT NULL REFERENCES listing(id) ON DELETE CASCADE,
+        sale_price decimal NOT NULL,
+        close_date date NOT NULL,
+        buyer_agent_id BIGINT REFERENCES users(id),
+        lease_term_months INT,
+        created_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW()
+    );
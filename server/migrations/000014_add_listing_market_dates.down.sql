@@ -0,0 +1,4 @@
+-- Filename: migrations/000014_add_listing_market_dates.down.sql
+
+ALTER TABLE listing DROP COLUMN IF EXISTS off_market_at;
+ALTER TABLE listing DROP COLUMN IF EXISTS listed_at;
@@ -0,0 +1,16 @@
+-- Filename: migrations/000014_add_listing_market_dates.up.sql
+
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS listed_at timestamp(0) with time zone;
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS off_market_at timestamp(0) with time zone;
+
+-- backfill existing listings, closed ones left the market on their close date
+
+UPDATE listing SET listed_at = created_at WHERE listed_at IS NULL;
+
+UPDATE listing l SET off_market_at = c.close_date
+FROM closings c
+WHERE c.listing_id = l.id AND l.off_market_at IS NULL;
+
+UPDATE listing l SET off_market_at = NOW()
+FROM propertystatus ps
+WHERE ps.id = l.propertystatusid AND ps.name <> 'Available' AND l.off_market_at IS NULL;
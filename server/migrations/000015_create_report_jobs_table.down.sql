@@ -0,0 +1,5 @@
+-- Filename: migrations/000015_create_report_jobs_table.down.sql
+
+DROP TABLE IF EXISTS report_runs;
+
+DROP TABLE IF EXISTS report_jobs;
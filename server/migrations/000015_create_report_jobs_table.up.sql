@@ -0,0 +1,28 @@
+-- Filename: migrations/000015_create_report_jobs_table.up.sql
+
+-- report jobs are emailed to the recipients on a cron schedule e.g. '0 8 * * 1' for Monday 8am
+
+CREATE TABLE
+    IF NOT EXISTS report_jobs(
+        id bigserial PRIMARY KEY,
+        name text NOT NULL,
+        report text NOT NULL,
+        schedule text NOT NULL,
+        recipients text[] NOT NULL,
+        enabled BOOL NOT NULL DEFAULT true,
+        created_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW()
+    );
+
+-- every run of a job is recorded with its result
+
+CREATE TABLE
+    IF NOT EXISTS report_runs(
+        id bigserial PRIMARY KEY,
+        job_id BIGINT NOT NULL REFERENCES report_jobs(id) ON DELETE CASCADE,
+        started_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+        finished_at timestamp(0) with time zone,
+        success BOOL NOT NULL DEFAULT false,
+        error text NOT NULL DEFAULT ''
+    );
@@ -0,0 +1,3 @@
+-- Filename: migrations/000016_add_listing_area.down.sql
+
+ALTER TABLE listing DROP COLUMN IF EXISTS area;
@@ -0,0 +1,5 @@
+-- Filename: migrations/000016_add_listing_area.up.sql
+
+-- floor area (or lot size for land) in square feet, used to find comparable sales
+
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS area decimal NOT NULL DEFAULT 0;
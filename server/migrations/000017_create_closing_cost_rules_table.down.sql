@@ -0,0 +1,5 @@
+-- Filename: migrations/000017_create_closing_cost_rules_table.down.sql
+
+DELETE FROM permissions WHERE code = 'rules:write';
+
+DROP TABLE IF EXISTS closing_cost_rules;
@@ -0,0 +1,27 @@
+-- Filename: migrations/000017_create_closing_cost_rules_table.up.sql
+
+-- rates are percentages, fees and thresholds are in the listing currency
+
+CREATE TABLE
+    IF NOT EXISTS closing_cost_rules(
+        id bigserial PRIMARY KEY,
+        buyer_type text UNIQUE NOT NULL,
+        transfer_tax_rate decimal NOT NULL,
+        exemption_threshold decimal NOT NULL DEFAULT 0,
+        legal_fee_rate decimal NOT NULL,
+        title_search_fee decimal NOT NULL,
+        version INT NOT NULL DEFAULT 1,
+        updated_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW()
+    );
+
+-- Belize stamp duty: residents pay 5% on the value above the exemption, foreign buyers 8%
+
+INSERT INTO closing_cost_rules(buyer_type, transfer_tax_rate, exemption_threshold, legal_fee_rate, title_search_fee)
+VALUES
+('resident', 5, 20000, 2, 100), ('foreign', 8, 0, 2, 100);
+
+INSERT INTO permissions(code)
+VALUES
+('rules:write');
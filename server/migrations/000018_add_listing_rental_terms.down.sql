@@ -0,0 +1,11 @@
+-- Filename: migrations/000018_add_listing_rental_terms.down.sql
+
+ALTER TABLE listing DROP CONSTRAINT IF EXISTS listing_type_check;
+
+ALTER TABLE listing DROP COLUMN IF EXISTS available_from;
+ALTER TABLE listing DROP COLUMN IF EXISTS pets_allowed;
+ALTER TABLE listing DROP COLUMN IF EXISTS utilities_included;
+ALTER TABLE listing DROP COLUMN IF EXISTS minimum_lease_months;
+ALTER TABLE listing DROP COLUMN IF EXISTS deposit;
+ALTER TABLE listing DROP COLUMN IF EXISTS rent_period;
+ALTER TABLE listing DROP COLUMN IF EXISTS listing_type;
@@ -0,0 +1,19 @@
+-- Filename: migrations/000018_add_listing_rental_terms.up.sql
+
+-- a listing is either for sale or for rent, for rentals the price is the rent per rent_period
+
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS listing_type text NOT NULL DEFAULT 'sale';
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS rent_period text NOT NULL DEFAULT '';
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS deposit decimal NOT NULL DEFAULT 0;
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS minimum_lease_months INT NOT NULL DEFAULT 0;
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS utilities_included BOOL NOT NULL DEFAULT false;
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS pets_allowed BOOL NOT NULL DEFAULT false;
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS available_from date;
+
+ALTER TABLE listing ADD CONSTRAINT listing_type_check CHECK (listing_type IN ('sale', 'rent'));
+
+-- listings that were already leased are rentals
+
+UPDATE listing l SET listing_type = 'rent', rent_period = 'monthly'
+FROM propertystatus ps
+WHERE ps.id = l.propertystatusid AND ps.name = 'Leased';
@@ -0,0 +1,5 @@
+-- Filename: migrations/000019_create_booking_calendar_tables.down.sql
+
+DROP TABLE IF EXISTS bookings;
+DROP TABLE IF EXISTS blocked_dates;
+DROP TABLE IF EXISTS nightly_rates;
@@ -0,0 +1,68 @@
+-- Filename: migrations/000019_create_booking_calendar_tables.up.sql
+
+-- needed so the bookings exclusion constraint can compare listing_id with =
+
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+-- nightly rates for a date range, the latest rate covering a night wins and
+-- nights without a rate are charged the listing price
+
+CREATE TABLE
+    IF NOT EXISTS nightly_rates(
+        id bigserial PRIMARY KEY,
+        listing_id BIGINT NOT NULL REFERENCES listing(id) ON DELETE CASCADE,
+        start_date date NOT NULL,
+        end_date date NOT NULL,
+        nightly_rate decimal NOT NULL,
+        created_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW(),
+            CHECK (end_date > start_date)
+    );
+
+CREATE INDEX IF NOT EXISTS nightly_rates_listing_id_idx ON nightly_rates(listing_id, start_date);
+
+-- nights the owner has closed, source is 'manual' or the url/upload an ical import came from
+
+CREATE TABLE
+    IF NOT EXISTS blocked_dates(
+        id bigserial PRIMARY KEY,
+        listing_id BIGINT NOT NULL REFERENCES listing(id) ON DELETE CASCADE,
+        start_date date NOT NULL,
+        end_date date NOT NULL,
+        reason text NOT NULL DEFAULT '',
+        source text NOT NULL DEFAULT 'manual',
+        uid text NOT NULL DEFAULT '',
+        created_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW(),
+            CHECK (end_date > start_date)
+    );
+
+CREATE INDEX IF NOT EXISTS blocked_dates_listing_id_idx ON blocked_dates(listing_id, start_date);
+
+-- check_out is the morning the guest leaves so the night of check_out is free,
+-- two live bookings of a listing can never share a night
+
+CREATE TABLE
+    IF NOT EXISTS bookings(
+        id bigserial PRIMARY KEY,
+        listing_id BIGINT NOT NULL REFERENCES listing(id) ON DELETE CASCADE,
+        guest_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        check_in date NOT NULL,
+        check_out date NOT NULL,
+        guests INT NOT NULL DEFAULT 1,
+        total_price decimal NOT NULL,
+        status text NOT NULL DEFAULT 'pending',
+        message text NOT NULL DEFAULT '',
+        version integer NOT NULL DEFAULT 1,
+        created_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW(),
+            CHECK (check_out > check_in),
+            CHECK (status IN ('pending', 'confirmed', 'declined', 'cancelled')),
+            CONSTRAINT bookings_no_overlap EXCLUDE USING gist (
+                listing_id WITH =,
+                daterange(check_in, check_out) WITH &&
+            ) WHERE (status IN ('pending', 'confirmed'))
+    );
@@ -0,0 +1,6 @@
+-- Filename: migrations/000020_create_organizations_table.down.sql
+
+ALTER TABLE listing DROP COLUMN IF EXISTS organization_id;
+
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
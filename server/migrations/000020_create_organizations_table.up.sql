@@ -0,0 +1,38 @@
+-- Filename: migrations/000020_create_organizations_table.up.sql
+
+CREATE TABLE
+    IF NOT EXISTS organizations(
+        id bigserial PRIMARY KEY,
+        name text NOT NULL,
+        slug text UNIQUE NOT NULL,
+        email text NOT NULL DEFAULT '',
+        phone text NOT NULL DEFAULT '',
+        address text NOT NULL DEFAULT '',
+        website text NOT NULL DEFAULT '',
+        description text NOT NULL DEFAULT '',
+        districtId INT REFERENCES district(id),
+        version INT NOT NULL DEFAULT 1,
+        created_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW()
+    );
+
+-- an agent works for one agency at a time, the broker is in charge of it
+
+CREATE TABLE
+    IF NOT EXISTS organization_members(
+        organization_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+        user_id BIGINT UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        role text NOT NULL DEFAULT 'agent',
+        joined_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW(),
+            PRIMARY KEY(organization_id, user_id),
+            CHECK (role IN ('broker', 'agent', 'admin'))
+    );
+
+-- the agency that owns a listing, the agent it is assigned to stays in userproperties
+
+ALTER TABLE listing ADD COLUMN IF NOT EXISTS organization_id BIGINT REFERENCES organizations(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS listing_organization_id_idx ON listing(organization_id);
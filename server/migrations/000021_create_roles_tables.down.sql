@@ -0,0 +1,9 @@
+-- Filename: migrations/000021_create_roles_tables.down.sql
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
+
+DELETE FROM permissions WHERE code IN ('users:read', 'users:write', 'profile:write', 'reports:read', 'lookups:read', 'permissions:write');
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
@@ -0,0 +1,51 @@
+-- Filename: migrations/000021_create_roles_tables.up.sql
+
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
+
+INSERT INTO permissions(code)
+VALUES
+('users:read'), ('users:write'), ('profile:write'), ('reports:read'), ('lookups:read'), ('permissions:write')
+ON CONFLICT (code) DO NOTHING;
+
+-- a role is a named set of permissions, a user's permissions are the ones
+-- granted to them directly plus the ones of their roles
+
+CREATE TABLE
+    IF NOT EXISTS roles(
+        id bigserial PRIMARY KEY,
+        name text UNIQUE NOT NULL,
+        description text NOT NULL DEFAULT ''
+    );
+
+CREATE TABLE
+    IF NOT EXISTS roles_permissions(
+        role_id BIGINT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+        permission_id BIGINT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+        PRIMARY KEY(role_id, permission_id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS users_roles(
+        user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        role_id BIGINT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+        PRIMARY KEY(user_id, role_id)
+    );
+
+INSERT INTO roles(name, description)
+VALUES
+('buyer', 'browses listings and books stays'),
+('agent', 'lists and sells properties'),
+('broker', 'runs an agency and reads its reports'),
+('admin', 'manages users, permissions and rules');
+
+INSERT INTO roles_permissions(role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE (r.name = 'buyer' AND p.code IN ('listings:read', 'profile:write', 'lookups:read'))
+OR (r.name = 'agent' AND p.code IN ('listings:read', 'listings:write', 'profile:write', 'lookups:read', 'users:read'))
+OR (r.name = 'broker' AND p.code IN ('listings:read', 'listings:write', 'profile:write', 'lookups:read', 'users:read', 'reports:read'))
+OR r.name = 'admin';
+
+-- everyone who has registered so far is at least a buyer
+
+INSERT INTO users_roles(user_id, role_id)
+SELECT u.id, r.id FROM users u, roles r WHERE r.name = 'buyer';
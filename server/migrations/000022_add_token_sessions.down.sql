@@ -0,0 +1,10 @@
+-- Filename: migrations/000022_add_token_sessions.down.sql
+
+DROP INDEX IF EXISTS tokens_user_id_idx;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS last_used_at,
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS id;
@@ -0,0 +1,9 @@
+-- Filename: migrations/000023_add_refresh_token_families.down.sql
+
+DELETE FROM tokens WHERE scope = 'refresh';
+
+DROP INDEX IF EXISTS tokens_family_idx;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS rotated_at,
+    DROP COLUMN IF EXISTS family;
@@ -0,0 +1,9 @@
+-- Filename: migrations/000023_add_refresh_token_families.up.sql
+
+-- every login starts a family, the access and refresh tokens issued by
+-- rotating its refresh token share it so the whole family can be revoked
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS family bytea,
+    ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens(family);
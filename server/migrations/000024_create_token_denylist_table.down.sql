@@ -0,0 +1,3 @@
+-- Filename: migrations/000024_create_token_denylist_table.down.sql
+
+DROP TABLE IF EXISTS token_denylist;
@@ -0,0 +1,15 @@
+-- Filename: migrations/000024_create_token_denylist_table.up.sql
+
+-- revoked signed access tokens. A row either names one token by jti or revokes every
+-- token of a user issued before created_at, rows can go once expiry has passed
+CREATE TABLE
+    IF NOT EXISTS token_denylist(
+        id bigserial PRIMARY KEY,
+        jti text,
+        user_id BIGINT REFERENCES users ON DELETE CASCADE,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+        CONSTRAINT token_denylist_target_check CHECK (jti IS NOT NULL OR user_id IS NOT NULL)
+    );
+
+CREATE INDEX IF NOT EXISTS token_denylist_expiry_idx ON token_denylist(expiry);
@@ -0,0 +1,8 @@
+-- Filename: migrations/000025_create_user_mfa_tables.down.sql
+
+DELETE FROM tokens WHERE scope = 'mfa';
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS attempts;
+
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
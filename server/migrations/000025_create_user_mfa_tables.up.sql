@@ -0,0 +1,25 @@
+-- Filename: migrations/000025_create_user_mfa_tables.up.sql
+
+-- a row is created when enrollment starts and enabled once the first code is confirmed,
+-- last_step is the newest TOTP step used so a code cannot be replayed
+CREATE TABLE
+    IF NOT EXISTS user_mfa(
+        user_id BIGINT PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+        secret text NOT NULL,
+        enabled boolean NOT NULL DEFAULT false,
+        last_step bigint NOT NULL DEFAULT 0,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        enabled_at TIMESTAMP(0) WITH TIME ZONE
+    );
+
+CREATE TABLE
+    IF NOT EXISTS mfa_recovery_codes(
+        id bigserial PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        hash bytea NOT NULL,
+        used_at TIMESTAMP(0) WITH TIME ZONE,
+        UNIQUE (user_id, hash)
+    );
+
+-- failed second factor attempts against an mfa token
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
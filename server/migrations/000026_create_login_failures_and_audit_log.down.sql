@@ -0,0 +1,6 @@
+-- Filename: migrations/000026_create_login_failures_and_audit_log.down.sql
+
+DELETE FROM tokens WHERE scope = 'unlock';
+
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS login_failures;
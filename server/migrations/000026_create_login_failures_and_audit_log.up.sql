@@ -0,0 +1,23 @@
+-- Filename: migrations/000026_create_login_failures_and_audit_log.up.sql
+
+-- failed logins counted per key, a key is a username (user:name) or a client address (ip:addr)
+CREATE TABLE
+    IF NOT EXISTS login_failures(
+        key text PRIMARY KEY,
+        failures integer NOT NULL DEFAULT 0,
+        last_failure_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        next_attempt_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        locked_until TIMESTAMP(0) WITH TIME ZONE
+    );
+
+CREATE TABLE
+    IF NOT EXISTS audit_log(
+        id bigserial PRIMARY KEY,
+        user_id BIGINT REFERENCES users ON DELETE SET NULL,
+        action text NOT NULL,
+        ip text NOT NULL DEFAULT '',
+        details jsonb NOT NULL DEFAULT '{}',
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS audit_log_user_id_idx ON audit_log(user_id);
@@ -0,0 +1,22 @@
+-- Filename: migrations/000027_create_api_keys_table.up.sql
+
+-- keys act as the user who made them with at most the permissions in scopes, keys made
+-- for an agency can also be seen and revoked by its brokers and admins
+CREATE TABLE
+    IF NOT EXISTS api_keys(
+        id bigserial PRIMARY KEY,
+        prefix text NOT NULL UNIQUE,
+        hash bytea NOT NULL,
+        name text NOT NULL,
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        organization_id BIGINT REFERENCES organizations ON DELETE CASCADE,
+        scopes text[] NOT NULL,
+        allowed_ips text[] NOT NULL DEFAULT '{}',
+        rate_limit integer NOT NULL DEFAULT 60,
+        expires_at TIMESTAMP(0) WITH TIME ZONE,
+        last_used_at TIMESTAMP(0) WITH TIME ZONE,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys(user_id);
+CREATE INDEX IF NOT EXISTS api_keys_organization_id_idx ON api_keys(organization_id);
@@ -0,0 +1,4 @@
+-- Filename: migrations/000028_create_user_identities_table.down.sql
+
+DROP TABLE IF EXISTS oidc_states;
+DROP TABLE IF EXISTS user_identities;
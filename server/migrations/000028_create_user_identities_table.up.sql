@@ -0,0 +1,27 @@
+-- Filename: migrations/000028_create_user_identities_table.up.sql
+
+-- accounts at outside identity providers, a user has at most one per provider
+CREATE TABLE
+    IF NOT EXISTS user_identities(
+        id bigserial PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        provider text NOT NULL,
+        subject text NOT NULL,
+        email text NOT NULL DEFAULT '',
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+        last_login_at TIMESTAMP(0) WITH TIME ZONE,
+        UNIQUE (provider, subject),
+        UNIQUE (user_id, provider)
+    );
+
+-- sign ins that have been sent to a provider and not come back yet, user_id is set
+-- when a signed in user is linking an identity
+CREATE TABLE
+    IF NOT EXISTS oidc_states(
+        hash bytea PRIMARY KEY,
+        provider text NOT NULL,
+        nonce text NOT NULL,
+        verifier text NOT NULL,
+        user_id BIGINT REFERENCES users ON DELETE CASCADE,
+        expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+    );
@@ -0,0 +1,3 @@
+-- Filename: migrations/000029_add_pending_email_to_users.down.sql
+
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
@@ -0,0 +1,4 @@
+-- Filename: migrations/000029_add_pending_email_to_users.up.sql
+
+-- a new email address waiting to be confirmed, users.email only changes once it is
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email text;
@@ -0,0 +1,4 @@
+-- Filename: migrations/000030_create_notification_preferences_table.down.sql
+
+ALTER TABLE users DROP COLUMN IF EXISTS closed_at;
+DROP TABLE IF EXISTS notification_preferences;
@@ -0,0 +1,12 @@
+-- Filename: migrations/000030_create_notification_preferences_table.up.sql
+
+-- users without a row get the defaults
+CREATE TABLE
+    IF NOT EXISTS notification_preferences(
+        user_id BIGINT PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+        report_digests boolean NOT NULL DEFAULT true,
+        updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+-- closed accounts are kept so listings and sales still point at them
+ALTER TABLE users ADD COLUMN IF NOT EXISTS closed_at TIMESTAMP(0) WITH TIME ZONE;
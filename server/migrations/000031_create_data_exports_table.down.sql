@@ -0,0 +1,4 @@
+-- Filename: migrations/000031_create_data_exports_table.down.sql
+
+ALTER TABLE users DROP COLUMN IF EXISTS erased_at;
+DROP TABLE IF EXISTS data_exports;
@@ -0,0 +1,17 @@
+-- Filename: migrations/000031_create_data_exports_table.up.sql
+
+-- personal data exports waiting to be downloaded, the link works once
+CREATE TABLE
+    IF NOT EXISTS data_exports(
+        id bigserial PRIMARY KEY,
+        user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+        hash bytea NOT NULL UNIQUE,
+        path text NOT NULL,
+        expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+        created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports(user_id);
+
+-- erased accounts keep their row with the personal data replaced
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP(0) WITH TIME ZONE;
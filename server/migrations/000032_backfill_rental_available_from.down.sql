@@ -0,0 +1,3 @@
+-- Filename: migrations/000032_backfill_rental_available_from.down.sql
+
+-- the backfilled dates cannot be told apart from ones set by agents, so they are kept
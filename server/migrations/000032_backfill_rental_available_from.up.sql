@@ -0,0 +1,7 @@
+-- Filename: migrations/000032_backfill_rental_available_from.up.sql
+
+-- rentals need an available_from date, the leased listings turned into rentals by 000018
+-- did not get one so they are taken as available from when they were listed
+
+UPDATE listing SET available_from = COALESCE(listed_at, created_at)::date
+WHERE listing_type = 'rent' AND available_from IS NULL;
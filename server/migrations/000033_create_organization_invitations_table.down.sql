@@ -0,0 +1,3 @@
+-- Filename: migrations/000033_create_organization_invitations_table.down.sql
+
+DROP TABLE IF EXISTS organization_invitations;
@@ -0,0 +1,20 @@
+-- Filename: migrations/000033_create_organization_invitations_table.up.sql
+
+-- a broker invites an agent and the agent only becomes a member once they accept
+
+CREATE TABLE
+    IF NOT EXISTS organization_invitations(
+        organization_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+        user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        role text NOT NULL DEFAULT 'agent',
+        expiry timestamp(0)
+        with
+            time zone NOT NULL,
+            created_at timestamp(0)
+        with
+            time zone NOT NULL DEFAULT NOW(),
+            PRIMARY KEY(organization_id, user_id),
+            CHECK (role IN ('broker', 'agent', 'admin'))
+    );
+
+CREATE INDEX IF NOT EXISTS organization_invitations_user_id_idx ON organization_invitations(user_id);
@@ -0,0 +1,3 @@
+-- Filename: migrations/000034_add_binding_to_oidc_states.down.sql
+
+ALTER TABLE oidc_states DROP COLUMN IF EXISTS binding_hash;
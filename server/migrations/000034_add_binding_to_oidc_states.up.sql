@@ -0,0 +1,5 @@
+-- Filename: migrations/000034_add_binding_to_oidc_states.up.sql
+
+-- the hash of the cookie given to the browser that started the sign in, the callback
+-- only works in that browser. Sign ins started before this have none and fail
+ALTER TABLE oidc_states ADD COLUMN IF NOT EXISTS binding_hash bytea NOT NULL DEFAULT ''::bytea;
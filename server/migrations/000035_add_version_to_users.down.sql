@@ -0,0 +1,3 @@
+-- Filename: migrations/000035_add_version_to_users.down.sql
+
+ALTER TABLE users DROP COLUMN IF EXISTS version;
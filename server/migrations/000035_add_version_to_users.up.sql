@@ -0,0 +1,4 @@
+-- Filename: migrations/000035_add_version_to_users.up.sql
+
+-- bumped on every profile edit so two edits at once do not overwrite each other
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+-- Filename: migrations/000036_denylist_created_at_to_the_microsecond.down.sql
+
+ALTER TABLE token_denylist ALTER COLUMN created_at TYPE TIMESTAMP(0) WITH TIME ZONE;
@@ -0,0 +1,5 @@
+-- Filename: migrations/000036_denylist_created_at_to_the_microsecond.up.sql
+
+-- user revocations are compared with the microsecond a token was signed, so a token
+-- refreshed in the same second as a permission change is not denied
+ALTER TABLE token_denylist ALTER COLUMN created_at TYPE TIMESTAMP(6) WITH TIME ZONE;